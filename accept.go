@@ -0,0 +1,96 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+   @Author: orbit-w
+   @File: accept
+   @2026 10月 周六 23:20
+*/
+
+// acceptAck is closed once the peer acknowledged the virtual conn with an Accept frame
+type acceptAck struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (a *acceptAck) done() {
+	a.once.Do(func() {
+		close(a.ch)
+	})
+}
+
+// waitAccept blocks until the peer accepts the virtual conn, rejects it, or ctx is done.
+// When ctx is done first the virtual conn is reset with CodeCancel.
+func (vc *VirtualConn) waitAccept(ctx context.Context) error {
+	select {
+	case <-vc.accepted.ch:
+		return nil
+	case <-vc.ctx.Done():
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-vc.accepted.ch:
+		return nil
+	default:
+	}
+	if err := vc.rb.GetErr(); err != nil {
+		// rejected by the peer or the multiplexer is closed
+		return err
+	}
+	_ = vc.Reset(CodeCancel, "open canceled")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrConnDone
+}
+
+// waitsForAccept reports whether NewVirtualConn waits for the peer's acknowledgement,
+// peers speaking a version older than ackProtocolVersion or no handshake at all never
+// send one. The handshake is awaited first, bounded by ctx.
+func (mux *Multiplexer) waitsForAccept(ctx context.Context) (bool, error) {
+	if !mux.isClient || !mux.conf.WaitForAccept {
+		return false, nil
+	}
+	if err := mux.awaitHandshake(ctx); err != nil {
+		return false, err
+	}
+	peer, ok := mux.PeerSettings()
+	return ok && peer.Version >= ackProtocolVersion, nil
+}
+
+// accept runs the accept hook of the server before the virtual conn is handled,
+// a rejected virtual conn is reset with the code and reason of the hook's error.
+// The peer is acknowledged if it asked for it in the handshake.
+func (mux *Multiplexer) accept(vc *VirtualConn) error {
+	if !mux.isClient && mux.server.conf.AcceptHook != nil {
+		if err := mux.server.conf.AcceptHook(vc.Context()); err != nil {
+			code, reason := CodeRefusedStream, err.Error()
+			var se *StatusError
+			if errors.As(err, &se) {
+				code, reason = se.Code, se.Message
+			}
+			_ = vc.Reset(code, reason)
+			return err
+		}
+	}
+
+	if peer, ok := mux.PeerSettings(); ok && peer.HasFeature(FeatureAcceptAck) {
+		return vc.mux.sched.pushInOrder(vc.Id(), vc.codec.Encode(&Msg{
+			Type: MessageAccept,
+			Id:   vc.Id(),
+		}))
+	}
+	return nil
+}
+
+func handleAccept(mux *Multiplexer, in *Msg) {
+	if v, ok := mux.virtualConns.Get(in.Id); ok {
+		v.accepted.done()
+	}
+}
@@ -0,0 +1,115 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/orbit-w/mux-go/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: accept_test
+   @2026 10月 周六 23:50
+*/
+
+func TestMultiplexer_WaitForAccept(t *testing.T) {
+	conf := DefaultServerConfig()
+	conf.AcceptHook = func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		token, _ := md.GetString("token")
+		switch token {
+		case "":
+			return NewStatusError(CodeRefusedStream, "missing token")
+		case "slow":
+			time.Sleep(time.Millisecond * 200)
+		case "busy":
+			return errors.New("server busy")
+		}
+		return nil
+	}
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return err
+		}
+		return conn.Send(in)
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, MuxClientConfig{WaitForAccept: true})
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	open := func(token string, timeout time.Duration) (IConn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if token != "" {
+			ctx = metadata.NewOutContext(ctx, map[string]any{"token": token})
+		}
+		return multiplexer.NewVirtualConn(ctx)
+	}
+
+	// rejected by the accept hook
+	_, err := open("", time.Second*5)
+	var re *ResetError
+	assert.ErrorAs(t, err, &re)
+	assert.True(t, re.Remote)
+	assert.Equal(t, CodeRefusedStream, re.Code)
+	assert.Equal(t, "missing token", re.Reason)
+
+	_, err = open("busy", time.Second*5)
+	assert.ErrorAs(t, err, &re)
+	assert.Equal(t, "server busy", re.Reason)
+
+	// the hook takes longer than the deadline
+	_, err = open("slow", time.Millisecond*50)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	vc, err := open("ok", time.Second*5)
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+
+	assert.Eventually(t, func() bool {
+		return multiplexer.(*Multiplexer).virtualConns.Len() == 0
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// 旧版本服务端不会确认虚拟连接，WaitForAccept 不等待确认
+func TestMultiplexer_WaitForAcceptLegacyPeer(t *testing.T) {
+	a, b := net.Pipe()
+	peer := newLegacyConn(t, NewFramedConn(b, 0))
+	defer peer.conn.Close()
+
+	multiplexer := NewMultiplexer(context.Background(), NewFramedConn(a, 0), MuxClientConfig{WaitForAccept: true})
+	defer multiplexer.Close()
+
+	opened := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		vc, err := multiplexer.NewVirtualConn(ctx)
+		if err == nil {
+			err = vc.Send([]byte("hello"))
+		}
+		opened <- err
+	}()
+
+	in, err := peer.recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int8(MessageStart), in.Type)
+	in, err = peer.recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in.Data))
+	assert.NoError(t, <-opened)
+}
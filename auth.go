@@ -0,0 +1,87 @@
+package mux
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+   @Author: orbit-w
+   @File: auth
+   @2026 10月 周一 10:20
+*/
+
+type identityKey struct{}
+
+// authIdentity wraps the identity so that a nil identity still marks the connection as authenticated
+type authIdentity struct {
+	identity any
+}
+
+// IdentityFromContext returns the identity MuxServerConfig.Authenticate returned for the
+// physical connection the virtual conn belongs to, ok is false without authentication
+// IdentityFromContext 返回虚拟连接所在物理连接的认证身份，未认证时 ok 为 false
+func IdentityFromContext(ctx context.Context) (identity any, ok bool) {
+	v, ok := ctx.Value(identityKey{}).(authIdentity)
+	return v.identity, ok
+}
+
+// sendAuth queues the client's credentials right after SETTINGS, so they reach the server
+// ahead of any virtual conn. A failure to get the credentials closes the multiplexer.
+func (mux *Multiplexer) sendAuth() {
+	if mux.conf.Credentials == nil {
+		return
+	}
+	creds, err := mux.conf.Credentials(mux.ctx)
+	if err != nil {
+		mux.closeWith(err)
+		return
+	}
+	_ = mux.sendMsg(&Msg{
+		Type: MessageAuth,
+		Data: creds,
+	})
+}
+
+func (mux *Multiplexer) requiresAuth() bool {
+	return !mux.isClient && mux.server.conf.Authenticate != nil
+}
+
+// authReason the reason sent to a rejected client, the error of Authenticate stays on the server
+const authReason = "authentication failed"
+
+// authDeadline aborts the physical connection with CodeUnauthenticated if the client's
+// credentials are not accepted within timeout
+func (mux *Multiplexer) authDeadline(timeout time.Duration) {
+	if !mux.requiresAuth() {
+		return
+	}
+	t := time.AfterFunc(timeout, func() {
+		if !mux.authed.Load() {
+			mux.connError(CodeUnauthenticated, "authentication timeout", ErrUnauthenticated)
+		}
+	})
+	mux.OnClose(func(error) {
+		t.Stop()
+	})
+}
+
+// handleAuth authenticates the physical connection, a rejected connection is aborted
+// with CodeUnauthenticated. Credentials are ignored by a server without Authenticate.
+func handleAuth(mux *Multiplexer, in *Msg) {
+	if !mux.requiresAuth() {
+		return
+	}
+	if mux.authed.Load() {
+		mux.connError(CodeProtocolError, "duplicate credentials", ErrProtocol)
+		return
+	}
+	identity, err := mux.server.conf.Authenticate(mux.ctx, in.Data)
+	if err != nil {
+		mux.connError(CodeUnauthenticated, authReason, fmt.Errorf("%w: %v", ErrUnauthenticated, err))
+		return
+	}
+	mux.identity = identity
+	mux.authed.Store(true)
+}
@@ -0,0 +1,139 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: auth_test
+   @2026 10月 周一 10:40
+*/
+
+func serveWithAuth(t *testing.T) *Server {
+	conf := DefaultServerConfig()
+	conf.Authenticate = func(ctx context.Context, creds []byte) (any, error) {
+		if string(creds) != "secret" {
+			return nil, errors.New("bad token")
+		}
+		return "user-1", nil
+	}
+	conf.AcceptHook = func(ctx context.Context) error {
+		if _, ok := IdentityFromContext(ctx); !ok {
+			return errors.New("no identity")
+		}
+		return nil
+	}
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		identity, _ := IdentityFromContext(conn.Context())
+		return conn.Send([]byte(identity.(string)))
+	}, conf))
+	return s
+}
+
+func dialWithCredentials(s *Server, creds func(ctx context.Context) ([]byte, error)) IMux {
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	conf := DefaultClientConfig()
+	conf.Credentials = creds
+	return NewMultiplexer(context.Background(), conn, conf)
+}
+
+func TestAuth(t *testing.T) {
+	s := serveWithAuth(t)
+	defer s.Stop()
+
+	multiplexer := dialWithCredentials(s, func(ctx context.Context) ([]byte, error) {
+		return []byte("secret"), nil
+	})
+	defer multiplexer.Close()
+
+	for i := 0; i < 2; i++ {
+		vc, err := multiplexer.NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+		in, err := vc.Recv(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", string(in))
+	}
+}
+
+// 认证失败或未发送凭据时整个物理连接被拒绝
+func TestAuth_Rejected(t *testing.T) {
+	s := serveWithAuth(t)
+	defer s.Stop()
+
+	cases := map[string]func(ctx context.Context) ([]byte, error){
+		"bad token": func(ctx context.Context) ([]byte, error) {
+			return []byte("guess"), nil
+		},
+		"no credentials": nil,
+	}
+	for name, creds := range cases {
+		t.Run(name, func(t *testing.T) {
+			multiplexer := dialWithCredentials(s, creds)
+			defer multiplexer.Close()
+
+			vc, err := multiplexer.NewVirtualConn(context.Background())
+			if err == nil {
+				_, err = vc.Recv(context.Background())
+			}
+			assert.ErrorIs(t, err, ErrUnauthenticated)
+			select {
+			case <-multiplexer.Done():
+			case <-time.After(time.Second * 5):
+				t.Fatal("physical connection not closed")
+			}
+			assert.ErrorIs(t, multiplexer.Err(), ErrUnauthenticated)
+			// the error of Authenticate is not disclosed to the client
+			assert.NotContains(t, multiplexer.Err().Error(), "bad token")
+		})
+	}
+}
+
+// 客户端在 AuthTimeout 内未通过认证时，整个物理连接被拒绝
+func TestAuth_Timeout(t *testing.T) {
+	conf := DefaultServerConfig()
+	conf.AuthTimeout = time.Millisecond * 100
+	conf.Authenticate = func(ctx context.Context, creds []byte) (any, error) {
+		return nil, nil
+	}
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", echoHandler, conf))
+	defer s.Stop()
+
+	// the client never sends credentials nor opens a virtual conn
+	multiplexer := dialWithCredentials(s, nil)
+	defer multiplexer.Close()
+	select {
+	case <-multiplexer.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("physical connection not closed")
+	}
+	assert.ErrorIs(t, multiplexer.Err(), ErrUnauthenticated)
+}
+
+func TestAuth_CredentialsFailed(t *testing.T) {
+	s := serveWithAuth(t)
+	defer s.Stop()
+
+	failed := errors.New("token expired")
+	multiplexer := dialWithCredentials(s, func(ctx context.Context) ([]byte, error) {
+		return nil, failed
+	})
+	<-multiplexer.Done()
+	assert.ErrorIs(t, multiplexer.Err(), failed)
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	_, ok := IdentityFromContext(context.Background())
+	assert.False(t, ok)
+	identity, ok := IdentityFromContext(context.WithValue(context.Background(), identityKey{}, authIdentity{}))
+	assert.True(t, ok)
+	assert.Nil(t, identity)
+}
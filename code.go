@@ -0,0 +1,44 @@
+package mux
+
+import "strconv"
+
+/*
+   @Author: orbit-w
+   @File: code
+   @2026 10月 周六 14:20
+*/
+
+// Code is carried by the frames that terminate a virtual conn abnormally
+// Code 虚拟连接异常终止时携带的错误码
+type Code uint32
+
+const (
+	CodeNoError          Code = iota //正常关闭
+	CodeProtocolError                //协议错误
+	CodeInternalError                //内部错误
+	CodeFlowControlError             //流控错误
+	CodeRefusedStream                //对端拒绝了虚拟连接，未做任何处理，可以安全重试
+	CodeCancel                       //虚拟连接被取消
+	CodeMessageTooLarge              //消息超过接收方的最大消息限制
+	CodeUnknown                      //处理函数返回的非 *StatusError 错误
+	CodeUnauthenticated              //物理连接未通过认证
+)
+
+var codeNames = map[Code]string{
+	CodeNoError:          "NO_ERROR",
+	CodeProtocolError:    "PROTOCOL_ERROR",
+	CodeInternalError:    "INTERNAL_ERROR",
+	CodeFlowControlError: "FLOW_CONTROL_ERROR",
+	CodeRefusedStream:    "REFUSED_STREAM",
+	CodeCancel:           "CANCEL",
+	CodeMessageTooLarge:  "MESSAGE_TOO_LARGE",
+	CodeUnknown:          "UNKNOWN",
+	CodeUnauthenticated:  "UNAUTHENTICATED",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "CODE(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
@@ -3,6 +3,8 @@ package mux
 import (
 	"encoding/binary"
 	"errors"
+	"sync/atomic"
+
 	"github.com/orbit-w/meteor/modules/net/packet"
 )
 
@@ -16,18 +18,53 @@ const (
 	typeFlagLength     = 1
 	endFlagLength      = 1
 	streamIdFlagLength = 8
+	codeLength         = 4
+
+	frameHeaderLength = typeFlagLength + endFlagLength + streamIdFlagLength
 )
 
-type Codec struct{}
+// compact (v2) frame layout:
+// type|compactMarker(1 byte) + flags(1 byte) + uvarint stream id + uvarint payload length + payload
+// the first byte of a v1 frame is a small type, the marker bit tells both layouts apart
+const (
+	compactMarker = 0x80
+
+	flagsLength = 1
+	// maxHeaderLength the largest header of both layouts
+	maxHeaderLength = typeFlagLength + flagsLength + binary.MaxVarintLen64 + binary.MaxVarintLen32
+)
+
+// Flags of a compact frame
+const (
+	FlagEnd        uint8 = 1 << iota //the sender finished the virtual conn
+	FlagCompressed                   //the payload is compressed
+	FlagFragment                     //the message continues in the next data frame
+)
+
+// Codec encodes v1 frames until the peer announced ProtocolVersion 2 in the handshake,
+// then it switches to the compact layout. Both layouts are always decoded.
+// Codec 对端在握手中通告协议版本2之后使用紧凑帧格式，解码时两种格式均支持
+type Codec struct {
+	compact atomic.Bool
+}
+
+// useCompact switches the encoding to the compact layout
+func (f *Codec) useCompact() {
+	f.compact.Store(true)
+}
 
 type Msg struct {
-	Type int8
-	End  bool
-	Id   int64
-	Data []byte
+	Type       int8
+	End        bool
+	Compressed bool //the payload is compressed, only carried by the compact layout
+	Id         int64
+	Data       []byte
 }
 
 func (f *Codec) Encode(msg *Msg) packet.IPacket {
+	if f.compact.Load() {
+		return f.encodeCompact(msg)
+	}
 	w := packet.WriterP(1 + 1 + 8 + len(msg.Data))
 	w.WriteInt8(msg.Type)
 	w.WriteBool(msg.End)
@@ -67,7 +104,71 @@ func (f *Codec) Decode(data []byte) (Msg, error) {
 	return msg, nil
 }
 
+func (f *Codec) encodeCompact(msg *Msg) packet.IPacket {
+	var head [maxHeaderLength]byte
+	ft, flags := msg.Type, uint8(0)
+	if ft == MessageFragment {
+		ft, flags = MessageRaw, flags|FlagFragment
+	}
+	if msg.End {
+		flags |= FlagEnd
+	}
+	if msg.Compressed {
+		flags |= FlagCompressed
+	}
+	head[0] = byte(ft) | compactMarker
+	head[1] = flags
+	n := typeFlagLength + flagsLength
+	n += binary.PutUvarint(head[n:], uint64(msg.Id))
+	n += binary.PutUvarint(head[n:], uint64(len(msg.Data)))
+
+	w := packet.WriterP(n + len(msg.Data))
+	w.Write(head[:n])
+	if data := msg.Data; len(data) > 0 {
+		msg.Data = nil
+		w.Write(data)
+	}
+	return w
+}
+
+func (f *Codec) decodeCompact(data []byte) (Msg, error) {
+	msg := Msg{}
+	if len(data) < typeFlagLength+flagsLength {
+		return msg, errors.New("decode failed")
+	}
+	msg.Type = int8(data[0] &^ compactMarker)
+	flags := data[1]
+	off := typeFlagLength + flagsLength
+
+	id, n := binary.Uvarint(data[off:])
+	if n <= 0 {
+		return msg, errors.New("decode failed: invalid stream id")
+	}
+	off += n
+	size, n := binary.Uvarint(data[off:])
+	if n <= 0 || size != uint64(len(data)-off-n) {
+		return msg, errors.New("decode failed: invalid payload length")
+	}
+	off += n
+
+	msg.Id = int64(id)
+	msg.End = flags&FlagEnd != 0
+	msg.Compressed = flags&FlagCompressed != 0
+	if flags&FlagFragment != 0 && msg.Type == MessageRaw {
+		msg.Type = MessageFragment
+	}
+	if size > 0 {
+		msg.Data = data[off:]
+	}
+	return msg, nil
+}
+
+// DecodeV2 decodes both the v1 and the compact layout without copying the payload
 func (f *Codec) DecodeV2(data []byte) (Msg, error) {
+	if len(data) > 0 && data[0]&compactMarker != 0 {
+		return f.decodeCompact(data)
+	}
+
 	msg := Msg{}
 	var off int
 	if len(data) < typeFlagLength+endFlagLength+streamIdFlagLength {
@@ -89,3 +190,57 @@ func (f *Codec) DecodeV2(data []byte) (Msg, error) {
 	}
 	return msg, nil
 }
+
+// encodeReset RST frame payload: code(4 bytes) + reason
+func encodeReset(code Code, reason string) []byte {
+	buf := make([]byte, codeLength+len(reason))
+	binary.BigEndian.PutUint32(buf, uint32(code))
+	copy(buf[codeLength:], reason)
+	return buf
+}
+
+// encodeStatus Fin frame payload: code(4 bytes) + uvarint message length + message + trailer,
+// an empty payload is a successful completion without trailer
+func encodeStatus(st *StatusError, trailer []byte) []byte {
+	if st == nil {
+		if len(trailer) == 0 {
+			return nil
+		}
+		st = &StatusError{Code: CodeNoError}
+	}
+	buf := make([]byte, codeLength, codeLength+binary.MaxVarintLen32+len(st.Message)+len(trailer))
+	binary.BigEndian.PutUint32(buf, uint32(st.Code))
+	buf = binary.AppendUvarint(buf, uint64(len(st.Message)))
+	buf = append(buf, st.Message...)
+	return append(buf, trailer...)
+}
+
+func decodeStatus(data []byte) (st *StatusError, trailer []byte, err error) {
+	if len(data) == 0 {
+		return nil, nil, nil
+	}
+	if len(data) < codeLength {
+		return nil, nil, errors.New("invalid status")
+	}
+	code := Code(binary.BigEndian.Uint32(data))
+	size, n := binary.Uvarint(data[codeLength:])
+	off := codeLength + n
+	if n <= 0 || uint64(len(data)-off) < size {
+		return nil, nil, errors.New("invalid status")
+	}
+	if end := off + int(size); end < len(data) {
+		trailer = data[end:]
+	}
+	if code == CodeNoError {
+		return nil, trailer, nil
+	}
+	return NewStatusError(code, string(data[off:off+int(size)])), trailer, nil
+}
+
+func decodeReset(data []byte) (Code, string, error) {
+	if len(data) < codeLength {
+		return 0, "", errors.New("invalid reset frame")
+	}
+	code := Code(binary.BigEndian.Uint32(data))
+	return code, string(data[codeLength:]), nil
+}
@@ -0,0 +1,75 @@
+package mux
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: codec_test
+   @2026 10月 周六 21:30
+*/
+
+func TestCodec_Compact(t *testing.T) {
+	v1 := new(Codec)
+	v2 := new(Codec)
+	v2.useCompact()
+
+	msgs := []Msg{
+		{Type: MessageRaw, Id: 1, Data: []byte("hello")},
+		{Type: MessageRaw, Id: 3, End: true},
+		{Type: MessageFragment, Id: 1 << 40, Data: []byte("chunk")},
+		{Type: MessageFin, Id: 5},
+		{Type: MessageWindowUpdate, Data: encodeWindowUpdate(1024)},
+	}
+	for i := range msgs {
+		in := msgs[i]
+		in.Data = append([]byte(nil), msgs[i].Data...)
+		compact := v2.Encode(&in).Data()
+
+		in.Data = append([]byte(nil), msgs[i].Data...)
+		legacy := v1.Encode(&in).Data()
+		assert.Less(t, len(compact), len(legacy))
+
+		// the receiver decodes both layouts
+		for _, data := range [][]byte{compact, legacy} {
+			out, err := v1.DecodeV2(data)
+			assert.NoError(t, err)
+			assert.Equal(t, msgs[i].Type, out.Type)
+			assert.Equal(t, msgs[i].Id, out.Id)
+			assert.Equal(t, msgs[i].End, out.End)
+			assert.Equal(t, len(msgs[i].Data), len(out.Data))
+			if len(out.Data) > 0 {
+				assert.Equal(t, msgs[i].Data, out.Data)
+			}
+		}
+	}
+
+	// small messages carry a 4 byte header
+	assert.Equal(t, 4+5, len(v2.Encode(&Msg{Type: MessageRaw, Id: 1, Data: []byte("hello")}).Data()))
+
+	compact := v2.Encode(&Msg{Type: MessageRaw, Id: 1, Data: []byte("hello")}).Data()
+	_, err := v1.DecodeV2(compact[:len(compact)-1])
+	assert.Error(t, err)
+	_, err = v1.DecodeV2(compact[:3])
+	assert.Error(t, err)
+}
+
+func TestCodec_Status(t *testing.T) {
+	assert.Nil(t, encodeStatus(nil, nil))
+	st, trailer, err := decodeStatus(encodeStatus(nil, []byte("trailer")))
+	assert.NoError(t, err)
+	assert.Nil(t, st)
+	assert.Equal(t, "trailer", string(trailer))
+
+	data := encodeStatus(NewStatusError(CodeUnknown, "boom"), nil)
+	st, trailer, err = decodeStatus(data)
+	assert.NoError(t, err)
+	assert.Equal(t, NewStatusError(CodeUnknown, "boom"), st)
+	assert.Nil(t, trailer)
+
+	_, _, err = decodeStatus(data[:len(data)-1])
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+package mux
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+/*
+   @Author: orbit-w
+   @File: compress
+   @2026 10月 周一 11:30
+*/
+
+// CompressorKey is the metadata key of the MessageStart frame carrying the compressor
+// chosen for the virtual conn, it is reserved by mux-go
+// CompressorKey 在 MessageStart 元数据中携带虚拟连接所选压缩算法的保留键
+const CompressorKey = "mux-compressor"
+
+// Compressor compresses the messages of the virtual conns that chose it by name,
+// both sides must register a compressor under the same name, see RegisterCompressor.
+// Decompress must stop with ErrMessageTooLarge once the output exceeds maxSize (0 means
+// no limit) rather than inflate the whole payload, a tiny payload may expand without bound.
+// Implementations must be safe for concurrent use.
+// Compressor 消息压缩算法，双方需以相同名称注册，实现需并发安全；
+// Decompress 输出超过 maxSize 时必须立即以 ErrMessageTooLarge 失败
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{}
+)
+
+func init() {
+	RegisterCompressor(newGzip())
+	deflate, _ := NewDeflateCompressor("deflate", flate.DefaultCompression, nil)
+	RegisterCompressor(deflate)
+}
+
+// RegisterCompressor makes c available to WithCompressor and to the peers choosing it,
+// a compressor registered under the same name is replaced. gzip and deflate are built in.
+// RegisterCompressor 注册压缩算法，同名覆盖；内置 gzip 与 deflate
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	compressors[c.Name()] = c
+	compressorsMu.Unlock()
+}
+
+// GetCompressor returns the compressor registered under name, nil if there is none
+func GetCompressor(name string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[name]
+}
+
+type compressorKey struct{}
+
+// WithCompressor makes the virtual conn opened by NewVirtualConn with ctx compress its
+// messages, and those of the peer, with the compressor registered under name.
+// Messages shorter than MinCompressSize, or sent before the settings handshake completed,
+// are sent as they are.
+// WithCompressor 为 NewVirtualConn 创建的虚拟连接选择压缩算法，双方的消息均按该算法压缩
+func WithCompressor(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, compressorKey{}, name)
+}
+
+func compressorFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(compressorKey{}).(string)
+	return name
+}
+
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func newGzip() *gzipCompressor {
+	c := &gzipCompressor{}
+	c.writers.New = func() any {
+		return gzip.NewWriter(nil)
+	}
+	return c
+}
+
+func (c *gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.writers.Get().(*gzip.Writer)
+	defer c.writers.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, maxSize)
+}
+
+// NewDeflateCompressor returns a deflate compressor with a preset dictionary, dict may be nil.
+// A dictionary made of content typical of the messages (e.g. common JSON keys) improves
+// the ratio of small messages, the peer must register the same dictionary under name.
+// NewDeflateCompressor 创建带预置字典的 deflate 压缩算法，对端需以相同名称注册相同字典
+func NewDeflateCompressor(name string, level int, dict []byte) (Compressor, error) {
+	if _, err := flate.NewWriterDict(io.Discard, level, dict); err != nil {
+		return nil, err
+	}
+	c := &deflateCompressor{name: name, level: level, dict: dict}
+	c.writers.New = func() any {
+		w, _ := flate.NewWriterDict(nil, c.level, c.dict)
+		return w
+	}
+	return c, nil
+}
+
+type deflateCompressor struct {
+	name    string
+	level   int
+	dict    []byte
+	writers sync.Pool
+}
+
+func (c *deflateCompressor) Name() string {
+	return c.name
+}
+
+func (c *deflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.writers.Get().(*flate.Writer)
+	defer c.writers.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *deflateCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(data), c.dict)
+	defer r.Close()
+	return readLimited(r, maxSize)
+}
+
+// readLimited reads r to the end, failing with ErrMessageTooLarge as soon as more than max bytes come out
+func readLimited(r io.Reader, max int) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, ErrMessageTooLarge
+	}
+	return out, nil
+}
+
+// compress compresses a message of the virtual conn if it chose a compressor, the message
+// is long enough and the peer decodes compressed frames
+func (vc *VirtualConn) compress(data []byte) ([]byte, bool, error) {
+	if vc.compressor == nil || len(data) < vc.mux.minCompressSize() || !vc.codec.compact.Load() {
+		return data, false, nil
+	}
+	if peer, ok := vc.mux.PeerSettings(); !ok || !peer.HasFeature(FeatureCompression) {
+		return data, false, nil
+	}
+	out, err := vc.compressor.Compress(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(out) >= len(data) {
+		// incompressible, not worth the work of the peer
+		return data, false, nil
+	}
+	return out, true, nil
+}
+
+// decompress unwraps a message buffered by a virtual conn with a compressor: a flag byte
+// telling whether it is compressed followed by the payload as received
+func (vc *VirtualConn) decompress(in []byte) ([]byte, error) {
+	flag, payload := in[0], in[1:]
+	vc.onRead(len(payload))
+	if flag == 0 {
+		return payload, nil
+	}
+	max := int(vc.mux.local.MaxMessageSize)
+	out, err := vc.compressor.Decompress(payload, max)
+	if err == nil && max > 0 && len(out) > max {
+		// a custom compressor ignoring the limit
+		err = ErrMessageTooLarge
+	}
+	switch {
+	case errors.Is(err, ErrMessageTooLarge):
+		_ = vc.Reset(CodeMessageTooLarge, "message exceeds the max message size")
+		return nil, ErrMessageTooLarge
+	case err != nil:
+		_ = vc.Reset(CodeProtocolError, "decompress failed")
+		return nil, err
+	}
+	return out, nil
+}
+
+// minCompressSize the shortest message compressed by this side
+func (mux *Multiplexer) minCompressSize() int {
+	if mux.isClient {
+		return mux.conf.MinCompressSize
+	}
+	return mux.server.conf.MinCompressSize
+}
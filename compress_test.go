@@ -0,0 +1,169 @@
+package mux
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: compress_test
+   @2026 10月 周一 11:50
+*/
+
+// countingCompressor counts the messages it compressed
+type countingCompressor struct {
+	Compressor
+	name string
+	n    atomic.Int32
+}
+
+func (c *countingCompressor) Name() string {
+	return c.name
+}
+
+func (c *countingCompressor) Compress(data []byte) ([]byte, error) {
+	c.n.Add(1)
+	return c.Compressor.Compress(data)
+}
+
+func echoServer(t *testing.T) *Server {
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", echoHandler, nil))
+	return s
+}
+
+func echo(t *testing.T, s *Server, name string, messages ...[]byte) {
+	multiplexer := NewMultiplexer(context.Background(), transport.DialContextWithOps(context.Background(), s.Addr()))
+	defer multiplexer.Close()
+	// messages sent ahead of the settings handshake are not compressed
+	waitSettings(t, multiplexer)
+
+	vc, err := multiplexer.NewVirtualConn(WithCompressor(context.Background(), name))
+	assert.NoError(t, err)
+	defer vc.CloseSend()
+	for _, msg := range messages {
+		assert.NoError(t, vc.Send(msg))
+		in, err := vc.Recv(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, msg, in)
+	}
+}
+
+func TestCompressor_Echo(t *testing.T) {
+	s := echoServer(t)
+	defer s.Stop()
+
+	large := bytes.Repeat([]byte("mux-go compress "), 64*1024) // split into fragments
+	for _, name := range []string{"gzip", "deflate"} {
+		t.Run(name, func(t *testing.T) {
+			echo(t, s, name, []byte("tiny"), []byte(strings.Repeat("a", 4096)), large)
+		})
+	}
+}
+
+// 短于 MinCompressSize 的消息不压缩
+func TestCompressor_MinSize(t *testing.T) {
+	s := echoServer(t)
+	defer s.Stop()
+
+	c := &countingCompressor{Compressor: GetCompressor("gzip"), name: "counting"}
+	RegisterCompressor(c)
+
+	echo(t, s, c.name, []byte("tiny"), bytes.Repeat([]byte("b"), MinCompressSize-1))
+	assert.Equal(t, int32(0), c.n.Load())
+
+	// both the client and the server compress
+	echo(t, s, c.name, bytes.Repeat([]byte("b"), MinCompressSize))
+	assert.Equal(t, int32(2), c.n.Load())
+}
+
+func TestCompressor_Dictionary(t *testing.T) {
+	s := echoServer(t)
+	defer s.Stop()
+
+	dict := []byte(`{"player_id":,"nickname":"","level":,"guild":""}`)
+	c, err := NewDeflateCompressor("deflate-dict", flate.BestCompression, dict)
+	assert.NoError(t, err)
+	RegisterCompressor(c)
+
+	msg := []byte(`{"player_id":1001,"nickname":"orbit","level":42,"guild":"mux"}`)
+	out, err := c.Compress(msg)
+	assert.NoError(t, err)
+	plain, _ := GetCompressor("deflate").Compress(msg)
+	assert.Less(t, len(out), len(plain))
+
+	in, err := c.Decompress(out, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, in)
+
+	echo(t, s, c.Name(), bytes.Repeat(msg, 64))
+}
+
+// 解压输出超过 MaxMessageSize 时立即失败，不会完整解压
+func TestCompressor_DecompressLimit(t *testing.T) {
+	bomb := make([]byte, 64*1024*1024)
+	for _, name := range []string{"gzip", "deflate"} {
+		c := GetCompressor(name)
+		out, err := c.Compress(bomb)
+		assert.NoError(t, err)
+		assert.Less(t, len(out), 1024*1024)
+
+		_, err = c.Decompress(out, MaxMessageSize)
+		assert.ErrorIs(t, err, ErrMessageTooLarge)
+		in, err := c.Decompress(out, len(bomb))
+		assert.NoError(t, err)
+		assert.Equal(t, len(bomb), len(in))
+	}
+
+	// the receiver enforces its limit even if the sender ignores it
+	conf := DefaultServerConfig()
+	conf.MaxMessageSize = 64 * 1024
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", echoHandler, conf))
+	defer s.Stop()
+
+	multiplexer := NewMultiplexer(context.Background(), transport.DialContextWithOps(context.Background(), s.Addr()))
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+	multiplexer.(*Multiplexer).maxMessageSize.Store(0)
+
+	vc, err := multiplexer.NewVirtualConn(WithCompressor(context.Background(), "gzip"))
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send(make([]byte, 16*1024*1024)))
+	_, err = vc.Recv(context.Background())
+	var re *ResetError
+	assert.ErrorAs(t, err, &re)
+	assert.True(t, re.Remote)
+	assert.Equal(t, CodeMessageTooLarge, re.Code)
+}
+
+func TestCompressor_Unknown(t *testing.T) {
+	s := echoServer(t)
+	defer s.Stop()
+
+	multiplexer := NewMultiplexer(context.Background(), transport.DialContextWithOps(context.Background(), s.Addr()))
+	defer multiplexer.Close()
+
+	_, err := multiplexer.NewVirtualConn(WithCompressor(context.Background(), "unknown"))
+	assert.ErrorIs(t, err, ErrUnknownCompressor)
+}
+
+func TestCodec_Compressed(t *testing.T) {
+	codec := new(Codec)
+	codec.useCompact()
+	for _, compressed := range []bool{true, false} {
+		msg := &Msg{Type: MessageRaw, Id: 3, Data: []byte("payload"), Compressed: compressed}
+		out, err := codec.DecodeV2(codec.Encode(msg).Data())
+		assert.NoError(t, err)
+		assert.Equal(t, compressed, out.Compressed)
+		assert.Equal(t, "payload", string(out.Data))
+	}
+}
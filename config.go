@@ -1,5 +1,10 @@
 package mux
 
+import (
+	"context"
+	"time"
+)
+
 /*
    @Author: orbit-w
    @File: config
@@ -7,7 +12,46 @@ package mux
 */
 
 type MuxClientConfig struct {
-	MaxVirtualConns int //最大流数
+	// MaxVirtualConns 最大流数，0 遵循服务端通告的 MaxConcurrentStreams（握手前为200），
+	// 设置后取二者中较小的值
+	MaxVirtualConns int
+
+	// KeepaliveInterval 保活PING间隔，0 使用默认值，负数关闭保活
+	KeepaliveInterval time.Duration
+	// KeepaliveTimeout 等待PONG的超时时间，超时则关闭多路复用器
+	KeepaliveTimeout time.Duration
+
+	// MaxFrameSize 可接收的最大帧，握手时通告给服务端，0 表示不通告
+	MaxFrameSize uint32
+	// InitialWindowSize 虚拟连接的初始接收窗口，握手时通告给服务端
+	InitialWindowSize uint32
+	// InitialConnWindowSize 物理连接的初始接收窗口，握手时通告给服务端
+	InitialConnWindowSize uint32
+	// MaxMessageSize 单个虚拟连接可重组的最大消息，握手时通告给服务端
+	MaxMessageSize uint32
+
+	// WaitForAccept makes NewVirtualConn wait until the server accepted or rejected the
+	// virtual conn, bounded by the context passed to NewVirtualConn.
+	// 为true时 NewVirtualConn 等待服务端接受或拒绝虚拟连接，等待时间受ctx限制
+	WaitForAccept bool
+
+	// Credentials returns the credentials sent to the server once per physical connection,
+	// ahead of any virtual conn, see MuxServerConfig.Authenticate.
+	// 每个物理连接建立时发送给服务端的认证凭据，先于所有虚拟连接发送
+	Credentials func(ctx context.Context) ([]byte, error)
+
+	// MinCompressSize 选择了压缩算法（WithCompressor）的虚拟连接，短于该长度的消息不压缩，0 使用默认值
+	MinCompressSize int
+
+	// AcceptHandler handles the virtual conns opened by the server (server push),
+	// server push is refused when it is nil.
+	// 处理服务端主动发起的虚拟连接，为nil时拒绝服务端推送
+	AcceptHandler func(conn IServerConn) error
+
+	// PanicHandler is called when the AcceptHandler panics, the virtual conn is then reset
+	// with CodeInternalError. The panic is logged when it is nil.
+	// PanicHandler AcceptHandler panic时调用，随后虚拟连接以 CodeInternalError 重置；为nil时输出panic值、堆栈与虚拟连接ID
+	PanicHandler func(info *PanicInfo)
 }
 
 const (
@@ -16,13 +60,24 @@ const (
 
 func DefaultClientConfig() MuxClientConfig {
 	return MuxClientConfig{
-		MaxVirtualConns: maxVirtualConns,
+		KeepaliveInterval:     KeepaliveInterval,
+		KeepaliveTimeout:      KeepaliveTimeout,
+		InitialWindowSize:     InitialWindowSize,
+		InitialConnWindowSize: InitialConnWindowSize,
+		MaxMessageSize:        MaxMessageSize,
+		MinCompressSize:       MinCompressSize,
 	}
 }
 
 func NewClientConfig(maxVirtualConns int) MuxClientConfig {
 	return MuxClientConfig{
-		MaxVirtualConns: maxVirtualConns,
+		MaxVirtualConns:       maxVirtualConns,
+		KeepaliveInterval:     KeepaliveInterval,
+		KeepaliveTimeout:      KeepaliveTimeout,
+		InitialWindowSize:     InitialWindowSize,
+		InitialConnWindowSize: InitialConnWindowSize,
+		MaxMessageSize:        MaxMessageSize,
+		MinCompressSize:       MinCompressSize,
 	}
 }
 
@@ -32,8 +87,53 @@ func parseConfig(params ...MuxClientConfig) MuxClientConfig {
 	}
 
 	conf := params[0]
-	if conf.MaxVirtualConns <= 0 {
-		conf.MaxVirtualConns = maxVirtualConns
+	if conf.MaxVirtualConns < 0 {
+		// unset, the limit advertised by the server applies
+		conf.MaxVirtualConns = 0
+	}
+	if conf.KeepaliveInterval == 0 {
+		conf.KeepaliveInterval = KeepaliveInterval
+	}
+	if conf.KeepaliveTimeout <= 0 {
+		conf.KeepaliveTimeout = KeepaliveTimeout
+	}
+	if conf.InitialWindowSize == 0 {
+		conf.InitialWindowSize = InitialWindowSize
+	}
+	if conf.InitialConnWindowSize == 0 {
+		conf.InitialConnWindowSize = InitialConnWindowSize
+	}
+	if conf.MaxMessageSize == 0 {
+		conf.MaxMessageSize = MaxMessageSize
+	}
+	if conf.MinCompressSize <= 0 {
+		conf.MinCompressSize = MinCompressSize
 	}
 	return conf
 }
+
+// maxVirtualConns returns the local limit of virtual conns before the handshake
+func (conf *MuxClientConfig) maxVirtualConns() int {
+	if conf.MaxVirtualConns > 0 {
+		return conf.MaxVirtualConns
+	}
+	return maxVirtualConns
+}
+
+func (conf *MuxClientConfig) toSettings() Settings {
+	s := Settings{
+		Version:               ProtocolVersion,
+		MaxFrameSize:          conf.MaxFrameSize,
+		InitialWindowSize:     conf.InitialWindowSize,
+		InitialConnWindowSize: conf.InitialConnWindowSize,
+		MaxMessageSize:        conf.MaxMessageSize,
+		Features:              FeatureCompression,
+	}
+	if conf.AcceptHandler != nil {
+		s.Features |= FeatureServerPush
+	}
+	if conf.WaitForAccept {
+		s.Features |= FeatureAcceptAck
+	}
+	return s
+}
@@ -19,16 +19,30 @@ type VirtualConns struct {
 	conns map[int64]*VirtualConn
 }
 
-func newConns(max int) *VirtualConns {
-	return &VirtualConns{
+// newConns the stream ID space is partitioned between the two sides,
+// virtual conns opened by the client use odd IDs and those opened by the server use even IDs.
+// 客户端发起的虚拟连接使用奇数ID，服务端发起的使用偶数ID，双方ID永不冲突
+func newConns(max int, isClient bool) *VirtualConns {
+	ins := &VirtualConns{
 		max:   max,
 		rw:    sync.RWMutex{},
 		conns: make(map[int64]*VirtualConn),
 	}
+	if isClient {
+		ins.idx.Store(-1)
+	}
+	return ins
 }
 
 func (ins *VirtualConns) Id() int64 {
-	return ins.idx.Add(1)
+	return ins.idx.Add(2)
+}
+
+// SetMax changes the limit of virtual conns, the ones already registered are kept
+func (ins *VirtualConns) SetMax(max int) {
+	ins.rw.Lock()
+	ins.max = max
+	ins.rw.Unlock()
 }
 
 func (ins *VirtualConns) Get(id int64) (*VirtualConn, bool) {
@@ -80,6 +94,20 @@ func (ins *VirtualConns) GetAndDel(id int64) (*VirtualConn, bool) {
 	return s, exist
 }
 
+// Range calls f for a snapshot of the virtual conns, f may modify VirtualConns
+func (ins *VirtualConns) Range(f func(stream *VirtualConn)) {
+	ins.rw.RLock()
+	t := make([]*VirtualConn, 0, len(ins.conns))
+	for k := range ins.conns {
+		t = append(t, ins.conns[k])
+	}
+	ins.rw.RUnlock()
+
+	for i := range t {
+		f(t[i])
+	}
+}
+
 func (ins *VirtualConns) OnClose(onClose func(stream *VirtualConn)) {
 	ins.rw.Lock()
 	defer ins.rw.Unlock()
@@ -14,7 +14,7 @@ import (
 */
 
 func TestVirtualConns_ConcurrentTesting(t *testing.T) {
-	mgr := newConns(10)
+	mgr := newConns(10, true)
 	wg := sync.WaitGroup{}
 	for i := 0; i < 1000; i++ {
 		wg.Add(1)
@@ -43,8 +43,17 @@ func TestVirtualConns_ConcurrentTesting(t *testing.T) {
 
 }
 
+func TestVirtualConns_Id(t *testing.T) {
+	cli := newConns(0, true)
+	svr := newConns(0, false)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, int64(1), cli.Id()%2)
+		assert.Equal(t, int64(0), svr.Id()%2)
+	}
+}
+
 func TestVirtualConns_Del(t *testing.T) {
-	mgr := newConns(10)
+	mgr := newConns(10, true)
 	id := mgr.Id()
 	assert.NoError(t, mgr.Reg(id, &VirtualConn{}))
 	mgr.Del(id)
@@ -14,20 +14,59 @@ const (
 	WriteTimeout      = time.Second * 5
 
 	DialTimeout = time.Second * 15
+
+	KeepaliveInterval = time.Second * 20 //保活PING间隔，需小于 ReadTimeout
+	KeepaliveTimeout  = time.Second * 20 //等待PONG的超时时间
+
+	AuthTimeout = time.Second * 10 //等待客户端凭据通过认证的超时时间
+)
+
+const (
+	InitialWindowSize     = 256 * 1024  //单个虚拟连接的初始发送窗口
+	InitialConnWindowSize = 1024 * 1024 //单个物理连接的初始发送窗口
 )
 
+// MaxConcurrentStreams the default limit of concurrent virtual conns per physical connection,
+// it bounds the data a server buffers for virtual conns that are not read
+const MaxConcurrentStreams = 200 //单个物理连接默认的最大并发虚拟连接数，限制未读取数据占用的内存
+
+const (
+	MaxFragmentSize = 16 * 1024       //单个数据帧的最大负载，更大的消息拆分为多个分片发送
+	MaxMessageSize  = 4 * 1024 * 1024 //单个虚拟连接可重组的最大消息
+	MinCompressSize = 1024            //选择了压缩算法的虚拟连接，短于该长度的消息不压缩
+)
+
+// MaxStreamId the largest virtual conn id of a physical connection, once a side runs out of ids
+// it sends GOAWAY and the physical connection is replaced by a fresh one
+// MaxStreamId 单个物理连接可用的最大虚拟连接ID，耗尽后发送GOAWAY，由新的物理连接接替
+const MaxStreamId int64 = 1<<31 - 1
+
 const (
 	StateMuxRunning = iota
 	StateMuxStopped
 )
 
+// states of a virtual conn, each direction is closed on its own:
+// ConnActive -> ConnWriteDone (CloseSend) or ConnReadDone (the peer's end of stream) -> ConnClosed
 const (
-	ConnActive uint32 = iota
-	ConnWriteDone
+	ConnActive    uint32 = iota
+	ConnWriteDone        //this side finished sending, it can still receive
+	ConnReadDone         //the peer finished sending, this side can still send
+	ConnClosed
 )
 
 const (
 	MessageRaw = iota
 	MessageStart
 	MessageFin
+	MessageWindowUpdate
+	MessageRst
+	MessagePing
+	MessagePong
+	MessageGoAway
+	MessageSettings
+	MessageFragment //a chunk of a message that continues in the next data frame
+	MessageHeader   //response metadata sent ahead of the first message
+	MessageAccept   //the virtual conn passed the accept hook of the peer
+	MessageAuth     //the credentials of the client, sent once after SETTINGS
 )
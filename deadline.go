@@ -0,0 +1,119 @@
+package mux
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+   @Author: orbit-w
+   @File: deadline
+   @2026 10月 周日 10:30
+*/
+
+// deadline is a resettable deadline, its context is canceled once the deadline passes.
+// Setting a new deadline after expiry renews the context, like net.Conn the new
+// deadline applies to pending and future calls.
+// deadline 可重置的截止时间，到期后 context 被取消，重新设置后生成新的 context
+type deadline struct {
+	mu     sync.Mutex
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	timer  *time.Timer
+}
+
+func newDeadline(parent context.Context) *deadline {
+	d := &deadline{parent: parent}
+	d.ctx, d.cancel = context.WithCancel(parent)
+	return d
+}
+
+// set arms the deadline, the zero value means no deadline
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired, wait for it to cancel the context
+		<-d.ctx.Done()
+	}
+	d.timer = nil
+
+	if d.ctx.Err() != nil && d.parent.Err() == nil {
+		d.ctx, d.cancel = context.WithCancel(d.parent)
+	}
+	if t.IsZero() {
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		d.timer = time.AfterFunc(dur, d.cancel)
+		return
+	}
+	d.cancel()
+}
+
+func (d *deadline) context() context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ctx
+}
+
+// exceeded reports whether ctx, returned by context, was canceled by the deadline
+// rather than by its parent
+func (d *deadline) exceeded(ctx context.Context) bool {
+	return ctx.Err() != nil && d.parent.Err() == nil
+}
+
+func (d *deadline) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// SetDeadline sets both the read and the write deadline, see SetReadDeadline and SetWriteDeadline
+func (vc *VirtualConn) SetDeadline(t time.Time) error {
+	vc.rd.set(t)
+	vc.wd.set(t)
+	return nil
+}
+
+// SetReadDeadline makes pending and future Recv calls fail with os.ErrDeadlineExceeded
+// once t passes, a zero t means Recv will not time out
+func (vc *VirtualConn) SetReadDeadline(t time.Time) error {
+	vc.rd.set(t)
+	return nil
+}
+
+// SetWriteDeadline makes pending and future Send calls fail with os.ErrDeadlineExceeded
+// once t passes, a zero t means Send will not time out
+func (vc *VirtualConn) SetWriteDeadline(t time.Time) error {
+	vc.wd.set(t)
+	return nil
+}
+
+// recvContext merges the read deadline into ctx, stop must be called once Recv returns
+func (vc *VirtualConn) recvContext(ctx context.Context, rd context.Context) (context.Context, func()) {
+	if ctx.Done() == nil {
+		return rd, func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(rd, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// deadlineErr maps the error of a call interrupted by the deadline to os.ErrDeadlineExceeded
+func deadlineErr(d *deadline, ctx context.Context, err error) error {
+	if err != nil && d.exceeded(ctx) && IsErrCanceled(err) {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
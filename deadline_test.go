@@ -0,0 +1,167 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: deadline_test
+   @2026 10月 周日 11:00
+*/
+
+func TestVirtualConn_ReadDeadline(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				return nil
+			}
+			if err = conn.Send(in); err != nil {
+				return err
+			}
+		}
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	// a pending Recv times out
+	assert.NoError(t, vc.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+	_, err = vc.Recv(context.Background())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	var ne net.Error
+	assert.True(t, errors.As(err, &ne) && ne.Timeout())
+
+	// and so do the future ones
+	_, err = vc.Recv(context.Background())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// extending the deadline wakes up nobody
+	done := make(chan error, 1)
+	assert.NoError(t, vc.SetReadDeadline(time.Now().Add(time.Hour)))
+	go func() {
+		_, err := vc.Recv(context.Background())
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	select {
+	case <-done:
+		t.Fatal("Recv returned before the deadline")
+	default:
+	}
+	// moving it to the past interrupts the pending Recv
+	assert.NoError(t, vc.SetReadDeadline(time.Now().Add(-time.Second)))
+	assert.ErrorIs(t, <-done, os.ErrDeadlineExceeded)
+
+	// the zero value clears the deadline, also with a caller's context
+	assert.NoError(t, vc.SetDeadline(time.Time{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, vc.Send([]byte("hello")))
+	in, err := vc.Recv(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+}
+
+func TestVirtualConn_WriteDeadline(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		// never reads, the send window is not replenished
+		<-conn.Context().Done()
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	// exhaust the window of the virtual conn
+	assert.NoError(t, vc.Send(make([]byte, InitialWindowSize)))
+
+	assert.NoError(t, vc.SetWriteDeadline(time.Now().Add(time.Millisecond*50)))
+	err = vc.Send([]byte("blocked"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.ErrorIs(t, vc.Send(nil), os.ErrDeadlineExceeded)
+
+	// the read deadline is set on its own
+	assert.NoError(t, vc.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+	_, err = vc.Recv(context.Background())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+// 等待物理连接窗口超时的 Send 归还已占用的虚拟连接窗口，清除期限后可继续发送
+func TestVirtualConn_WriteDeadlineConnQuota(t *testing.T) {
+	s := serveWithHandler(t, Dev, echoHandler)
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	m := multiplexer.(*Multiplexer)
+	// exhaust the window of the physical connection only
+	connQuota := m.sendQuota.quota
+	m.sendQuota.replenish(int(-connQuota))
+	streamQuota := vc.(*VirtualConn).sendQuota.quota
+
+	assert.NoError(t, vc.SetWriteDeadline(time.Now().Add(time.Millisecond*50)))
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, vc.Send([]byte("blocked")), os.ErrDeadlineExceeded)
+	}
+	assert.Equal(t, streamQuota, vc.(*VirtualConn).sendQuota.quota)
+
+	m.sendQuota.replenish(int(connQuota))
+	assert.NoError(t, vc.SetWriteDeadline(time.Time{}))
+	assert.NoError(t, vc.Send([]byte("hello")))
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+}
+
+// 写期限已过时 CloseSend 仍通知对端结束发送方向
+func TestVirtualConn_CloseSendAfterWriteDeadline(t *testing.T) {
+	ended := make(chan error, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		_, err := conn.Recv(context.Background())
+		ended <- err
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.SetWriteDeadline(time.Now().Add(-time.Second)))
+	assert.NoError(t, vc.CloseSend())
+
+	select {
+	case err = <-ended:
+		assert.Equal(t, io.EOF, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("End frame not received")
+	}
+}
@@ -3,6 +3,7 @@ package mux
 import (
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -16,8 +17,76 @@ var (
 	ErrCancel             = errors.New("context canceled")
 	ErrConnDone           = errors.New("error_the_conn_is_done")
 	ErrVirtualConnUpLimit = errors.New("error_virtual_conn_up_limit")
+	ErrMuxClosed          = errors.New("error_the_mux_is_closed")
+	ErrKeepaliveTimeout   = errors.New("error_keepalive_timeout")
+	ErrVersionMismatch    = errors.New("error_protocol_version_mismatch")
+	ErrMessageTooLarge    = errors.New("error_message_too_large")
+	ErrPushDisabled       = errors.New("error_server_push_disabled")
+	ErrHeaderSent         = errors.New("error_header_already_sent")
+	ErrServerClosed       = errors.New("error_server_closed")
+	ErrAcceptDisabled     = errors.New("error_server_has_handler")
+	ErrProtocol           = errors.New("error_protocol_violation")
+	ErrPacketTooLarge     = errors.New("error_packet_too_large")
+	ErrUnauthenticated    = errors.New("error_unauthenticated")
+	ErrUnknownCompressor  = errors.New("error_unknown_compressor")
+	ErrFlowControl        = errors.New("error_flow_control_violation")
+	ErrGzipUnsupported    = errors.New("error_gzip_unsupported_by_framed_transport")
+
+	// ErrGoAway the multiplexer is draining, the virtual conn was not processed by the peer
+	// and can be safely retried on another multiplexer
+	// ErrGoAway 多路复用器正在优雅关闭，虚拟连接未被对端处理，可以在其他多路复用器上安全重试
+	ErrGoAway = errors.New("error_mux_go_away")
 )
 
+// ResetError is returned by Recv when the virtual conn was aborted with a RST frame
+// ResetError 虚拟连接被RST帧终止时由Recv返回
+type ResetError struct {
+	Code   Code
+	Reason string
+	Remote bool //true if the peer reset the virtual conn
+}
+
+func (e *ResetError) Error() string {
+	msg := "virtual conn reset"
+	if e.Remote {
+		msg = "virtual conn reset by peer"
+	}
+	msg = fmt.Sprintf("%s: code=%s", msg, e.Code)
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s, reason=%s", msg, e.Reason)
+	}
+	return msg
+}
+
+// StatusError is the status a handler finished the virtual conn with, the peer's Recv
+// returns it instead of io.EOF when the code is not CodeNoError
+// StatusError 处理函数结束虚拟连接时的状态，错误码不为 CodeNoError 时对端的Recv返回该错误
+type StatusError struct {
+	Code    Code
+	Message string
+}
+
+func NewStatusError(code Code, msg string) *StatusError {
+	return &StatusError{Code: code, Message: msg}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("virtual conn status: code=%s, message=%s", e.Code, e.Message)
+}
+
+// toStatus converts the error returned by a handler,
+// nil and io.EOF complete the virtual conn successfully
+func toStatus(err error) *StatusError {
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se
+	}
+	return NewStatusError(CodeUnknown, err.Error())
+}
+
 func IsErrCanceled(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "context canceled")
 }
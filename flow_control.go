@@ -0,0 +1,166 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+   @Author: orbit-w
+   @File: flow_control
+   @2026 10月 周六 10:12
+*/
+
+const windowUpdateLength = 4
+
+// writeQuota is the send window of a virtual conn or of a whole multiplexer.
+// get blocks while the quota is exhausted, a single message may overdraw it,
+// so messages larger than the window never deadlock; only one message overdraws at a time.
+// The window is not enforced for legacy peers (see handshake.negotiated), the bytes are still counted.
+// writeQuota 发送窗口，窗口耗尽时 get 阻塞，单条消息允许透支窗口；旧版本对端不受窗口限制
+type writeQuota struct {
+	quota int64
+	ch    chan struct{}
+	done  <-chan struct{}
+	hs    *handshake
+}
+
+func newWriteQuota(sz int32, done <-chan struct{}, hs *handshake) *writeQuota {
+	return &writeQuota{
+		quota: int64(sz),
+		ch:    make(chan struct{}, 1),
+		done:  done,
+		hs:    hs,
+	}
+}
+
+func (w *writeQuota) get(ctx context.Context, sz int32) error {
+	for {
+		q := atomic.LoadInt64(&w.quota)
+		if q > 0 || w.hs.legacy() {
+			if !atomic.CompareAndSwapInt64(&w.quota, q, q-int64(sz)) {
+				continue
+			}
+			if q-int64(sz) > 0 {
+				// there is still credit left, let the next waiter go
+				w.wakeUp()
+			}
+			return nil
+		}
+		select {
+		case <-w.ch:
+		case <-w.hs.pending():
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.done:
+			return ErrConnDone
+		}
+	}
+}
+
+func (w *writeQuota) replenish(n int) {
+	sz := int64(n)
+	a := atomic.AddInt64(&w.quota, sz)
+	b := a - sz
+	if b <= 0 && a > 0 {
+		w.wakeUp()
+	}
+}
+
+func (w *writeQuota) wakeUp() {
+	select {
+	case w.ch <- struct{}{}:
+	default:
+	}
+}
+
+// inFlow accounts for the bytes received on a virtual conn or a multiplexer
+// and decides when the consumed credit is returned to the peer.
+// inFlow 统计接收方已接收/已消费的字节数，决定何时向对端归还窗口
+type inFlow struct {
+	mu            sync.Mutex
+	limit         uint32
+	max           uint64 // the most bytes the peer may have in flight, see flowLimit
+	pendingData   uint32 // received but not yet consumed by Recv
+	pendingUpdate uint32 // consumed but not yet returned to the peer
+	closed        bool
+}
+
+func newInFlow(limit uint32, max uint64) *inFlow {
+	return &inFlow{
+		limit: limit,
+		max:   max,
+	}
+}
+
+// flowLimit the most bytes a peer respecting window may have in flight: until it receives
+// the SETTINGS of this side it uses the default window def, and one message may overdraw it
+func flowLimit(window, def, maxMessage uint32) uint64 {
+	return uint64(max(window, def)) + uint64(maxMessage)
+}
+
+// onData is called when n bytes arrive, it returns false if the receiver is closed
+// and the data must be discarded. With enforce, ErrFlowControl is returned when the
+// peer overran the window.
+func (f *inFlow) onData(n uint32, enforce bool) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false, nil
+	}
+	if enforce && uint64(f.pendingData)+uint64(f.pendingUpdate)+uint64(n) > f.max {
+		return false, ErrFlowControl
+	}
+	f.pendingData += n
+	return true, nil
+}
+
+// onRead is called when n bytes are consumed, it returns the window update
+// that should be sent to the peer (0 means none yet).
+// ok is false once the receiver is closed, the bytes were already returned by close.
+func (f *inFlow) onRead(n uint32) (wu uint32, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, false
+	}
+	if n > f.pendingData {
+		n = f.pendingData
+	}
+	f.pendingData -= n
+	f.pendingUpdate += n
+	if f.pendingUpdate >= f.limit/4 {
+		wu = f.pendingUpdate
+		f.pendingUpdate = 0
+	}
+	return wu, true
+}
+
+// close stops the accounting and returns the bytes that were received but never consumed.
+func (f *inFlow) close() uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0
+	}
+	f.closed = true
+	n := f.pendingData
+	f.pendingData = 0
+	return n
+}
+
+func encodeWindowUpdate(n uint32) []byte {
+	buf := make([]byte, windowUpdateLength)
+	binary.BigEndian.PutUint32(buf, n)
+	return buf
+}
+
+func decodeWindowUpdate(data []byte) (uint32, error) {
+	if len(data) < windowUpdateLength {
+		return 0, errors.New("invalid window update")
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
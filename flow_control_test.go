@@ -0,0 +1,260 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: flow_control_test
+   @2026 10月 周六 11:05
+*/
+
+func newHandshake(negotiated bool) *handshake {
+	hs := new(handshake)
+	hs.init()
+	hs.resolve(negotiated)
+	return hs
+}
+
+func TestWriteQuota_Get(t *testing.T) {
+	done := make(chan struct{})
+	wq := newWriteQuota(10, done, newHandshake(true))
+	assert.NoError(t, wq.get(context.Background(), 20))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Error(t, wq.get(ctx, 1))
+
+	go wq.replenish(20)
+	assert.NoError(t, wq.get(context.Background(), 1))
+
+	wq.replenish(-19)
+	close(done)
+	assert.ErrorIs(t, wq.get(context.Background(), 1), ErrConnDone)
+
+	// legacy peer: the bytes are counted but never waited for
+	legacy := newWriteQuota(10, make(chan struct{}), newHandshake(false))
+	assert.NoError(t, legacy.get(context.Background(), 20))
+	assert.NoError(t, legacy.get(context.Background(), 20))
+	assert.Equal(t, int64(-30), legacy.quota)
+
+	// a sender waiting for the handshake is released once the peer turns out to be legacy
+	hs := new(handshake)
+	hs.init()
+	pending := newWriteQuota(10, make(chan struct{}), hs)
+	assert.NoError(t, pending.get(context.Background(), 20))
+	go hs.resolve(false)
+	assert.NoError(t, pending.get(context.Background(), 1))
+}
+
+func TestInFlow(t *testing.T) {
+	f := newInFlow(100, flowLimit(100, 50, 20))
+	ok, err := f.onData(60, true)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	wu, ok := f.onRead(10)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(0), wu)
+	wu, ok = f.onRead(20)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(30), wu)
+
+	// the window plus one message is in flight at most
+	ok, err = f.onData(90, true)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	_, err = f.onData(1, true)
+	assert.ErrorIs(t, err, ErrFlowControl)
+	// not enforced for legacy peers
+	ok, err = f.onData(1, false)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint32(121), f.close())
+	ok, _ = f.onData(10, true)
+	assert.False(t, ok)
+	_, ok = f.onRead(10)
+	assert.False(t, ok)
+	assert.Equal(t, uint32(0), f.close())
+}
+
+// A slow reader must hold back the sender instead of buffering without limit
+// 慢速读取端会阻塞发送端，而不是无限制地缓存数据
+func TestVirtualConn_FlowControl(t *testing.T) {
+	var (
+		buf     = make([]byte, 64*1024)
+		release = make(chan struct{})
+		total   = InitialWindowSize * 4
+		read    atomic.Int64
+	)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		<-release
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				break
+			}
+			read.Add(int64(len(in)))
+		}
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	var sent atomic.Int64
+	complete := make(chan struct{})
+	go func() {
+		for sent.Load() < int64(total) {
+			if err := vc.Send(buf); err != nil {
+				break
+			}
+			sent.Add(int64(len(buf)))
+		}
+		close(complete)
+	}()
+
+	time.Sleep(time.Millisecond * 200)
+	assert.LessOrEqual(t, sent.Load(), int64(InitialWindowSize+len(buf)))
+
+	close(release)
+	select {
+	case <-complete:
+	case <-time.After(time.Second * 5):
+		t.Fatal("send blocked after the reader resumed")
+	}
+	assert.NoError(t, vc.CloseSend())
+
+	for {
+		if _, err = vc.Recv(context.Background()); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(total), read.Load())
+}
+
+// 未发送SETTINGS的旧版本对端不会归还窗口，发送端不受窗口限制
+func TestVirtualConn_FlowControlLegacyPeer(t *testing.T) {
+	a, b := net.Pipe()
+	peer := NewFramedConn(b, 0)
+	defer peer.Close()
+	go func() {
+		for {
+			if _, err := peer.Recv(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	multiplexer := NewMultiplexer(context.Background(), NewFramedConn(a, 0))
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	// the sender would block for good once the window is used up
+	buf := make([]byte, 8*1024)
+	assert.NoError(t, vc.SetWriteDeadline(time.Now().Add(time.Second*5)))
+	for sent := 0; sent < InitialConnWindowSize*2; sent += len(buf) {
+		if !assert.NoError(t, vc.Send(buf)) {
+			return
+		}
+	}
+}
+
+// The connection window is returned once the data reaches its virtual conn,
+// the virtual conns that are not read never hold back the others
+// 未被读取的虚拟连接不会耗尽物理连接的窗口
+func TestVirtualConn_FlowControlUnread(t *testing.T) {
+	s := serveWithHandler(t, Dev, echoHandler)
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	// the echoes of the unread virtual conns exceed the connection window
+	buf := make([]byte, MaxFragmentSize)
+	for i := 0; i < 8; i++ {
+		vc, err := multiplexer.NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+		for sent := 0; sent < InitialWindowSize; sent += len(buf) {
+			assert.NoError(t, vc.Send(buf))
+		}
+	}
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	in, err := vc.Recv(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+}
+
+// 对端超出窗口发送数据时，虚拟连接以 CodeFlowControlError 重置
+func TestVirtualConn_FlowControlOverrun(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	conf := DefaultServerConfig()
+	conf.MaxMessageSize = 16 * 1024
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		<-release
+		return nil
+	}, conf))
+	defer s.Stop()
+
+	multiplexer := NewMultiplexer(context.Background(), transport.DialContextWithOps(context.Background(), s.Addr()))
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	// the sender ignores the windows of the server
+	vc.(*VirtualConn).sendQuota.replenish(1 << 30)
+	multiplexer.(*Multiplexer).sendQuota.replenish(1 << 30)
+
+	buf := make([]byte, 8*1024)
+	for sent := 0; sent < InitialWindowSize*2; sent += len(buf) {
+		if err = vc.Send(buf); err != nil {
+			break
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err = vc.Recv(ctx)
+	var re *ResetError
+	if assert.ErrorAs(t, err, &re) {
+		assert.True(t, re.Remote)
+		assert.Equal(t, CodeFlowControlError, re.Code)
+	}
+}
+
+// 服务端默认限制并发虚拟连接数，未读取数据占用的内存有上限
+func TestServerConfig_MaxConcurrentStreams(t *testing.T) {
+	var conf *MuxServerConfig
+	buildServerConfig(&conf)
+	assert.Equal(t, uint32(MaxConcurrentStreams), conf.MaxConcurrentStreams)
+	conf = &MuxServerConfig{}
+	buildServerConfig(&conf)
+	assert.Equal(t, uint32(MaxConcurrentStreams), conf.MaxConcurrentStreams)
+}
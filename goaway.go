@@ -0,0 +1,118 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+   @Author: orbit-w
+   @File: goaway
+   @2026 10月 周六 17:10
+*/
+
+const goAwayLength = streamIdFlagLength + codeLength
+
+// GoAway starts draining the multiplexer: the peer is told that no virtual conn
+// above the last one accepted here will be processed, the virtual conns in flight
+// are allowed to finish and the physical connection is closed once they are all done.
+// NewVirtualConn returns ErrGoAway from now on.
+// GoAway 开始优雅关闭多路复用器：通知对端不会再处理新的虚拟连接，已有虚拟连接可以正常结束，
+// 全部结束后关闭物理连接。此后 NewVirtualConn 返回 ErrGoAway
+func (mux *Multiplexer) GoAway() error {
+	if mux.state.Load() != StateMuxRunning {
+		return ErrMuxClosed
+	}
+
+	mux.acceptMu.Lock()
+	if mux.localGoAway {
+		mux.acceptMu.Unlock()
+		return nil
+	}
+	mux.localGoAway = true
+	mux.goingAway.Store(true)
+	err := mux.sendMsg(&Msg{
+		Type: MessageGoAway,
+		Data: encodeGoAway(mux.lastPeerId, CodeNoError, ""),
+	})
+	mux.acceptMu.Unlock()
+
+	mux.tryDrain()
+	return err
+}
+
+// connError aborts the physical connection, the peer is told the reason with a GOAWAY frame
+// and the virtual conns fail with err
+func (mux *Multiplexer) connError(code Code, reason string, err error) {
+	_ = mux.sendMsg(&Msg{
+		Type: MessageGoAway,
+		Data: encodeGoAway(mux.lastPeerId, code, reason),
+	})
+	mux.closeWith(fmt.Errorf("%w: %s", err, reason))
+}
+
+// peerConnErr the error of a physical connection aborted by the peer with code
+func peerConnErr(code Code, reason string) error {
+	err := ErrProtocol
+	switch code {
+	case CodeUnauthenticated:
+		err = ErrUnauthenticated
+	case CodeFlowControlError:
+		err = ErrFlowControl
+	}
+	return fmt.Errorf("%w: aborted by peer, code=%s, reason=%s", err, code, reason)
+}
+
+func (mux *Multiplexer) isGoingAway() bool {
+	return mux.goingAway.Load()
+}
+
+// tryDrain closes the multiplexer when it is going away and the last virtual conn is done
+func (mux *Multiplexer) tryDrain() {
+	if mux.isGoingAway() && mux.virtualConns.Len() == 0 {
+		mux.closeWith(ErrGoAway)
+	}
+}
+
+// handleGoAway the peer is draining the physical connection, the virtual conns opened
+// by this side that the peer will never process are failed with the retryable ErrGoAway
+func handleGoAway(mux *Multiplexer, in *Msg) {
+	lastId, code, reason, err := decodeGoAway(in.Data)
+	if err != nil {
+		return
+	}
+	mux.goingAway.Store(true)
+	if code != CodeNoError {
+		// the peer aborted the physical connection, nothing is retryable
+		mux.closeWith(peerConnErr(code, reason))
+		return
+	}
+
+	mux.virtualConns.Range(func(vc *VirtualConn) {
+		if vc.isClient() && vc.Id() > lastId {
+			if _, ok := mux.virtualConns.GetAndDel(vc.Id()); ok {
+				vc.OnClose(ErrGoAway)
+			}
+		}
+	})
+	mux.tryDrain()
+}
+
+// encodeGoAway GOAWAY frame payload: last stream id(8 bytes) + code(4 bytes) + reason
+func encodeGoAway(lastId int64, code Code, reason string) []byte {
+	buf := make([]byte, goAwayLength+len(reason))
+	binary.BigEndian.PutUint64(buf, uint64(lastId))
+	binary.BigEndian.PutUint32(buf[streamIdFlagLength:], uint32(code))
+	copy(buf[goAwayLength:], reason)
+	return buf
+}
+
+func decodeGoAway(data []byte) (int64, Code, string, error) {
+	if len(data) < goAwayLength {
+		return 0, 0, "", errors.New("invalid goaway frame")
+	}
+	lastId := int64(binary.BigEndian.Uint64(data))
+	code := Code(binary.BigEndian.Uint32(data[streamIdFlagLength:]))
+	return lastId, code, string(data[goAwayLength:]), nil
+}
@@ -0,0 +1,95 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: goaway_test
+   @2026 10月 周六 17:55
+*/
+
+// 服务端发送GOAWAY后，已有虚拟连接可以正常结束，新的虚拟连接返回 ErrGoAway，排空后物理连接关闭
+func TestServer_GoAway(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				return nil
+			}
+			if err = conn.Send(in); err != nil {
+				return err
+			}
+		}
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+	_, err = vc.Recv(context.Background())
+	assert.NoError(t, err)
+
+	s.GoAway()
+	assert.Eventually(t, func() bool {
+		_, err := multiplexer.NewVirtualConn(context.Background())
+		return err == ErrGoAway
+	}, time.Second*5, time.Millisecond*10)
+
+	// the virtual conn in flight keeps working
+	assert.NoError(t, vc.Send([]byte("in flight")))
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "in flight", string(in))
+
+	assert.NoError(t, vc.CloseSend())
+	_, err = vc.Recv(context.Background())
+	assert.Equal(t, io.EOF, err)
+
+	// drained, the physical connection goes away
+	assert.Eventually(t, func() bool {
+		_, err := multiplexer.Ping(context.Background())
+		return err == ErrMuxClosed
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// 收到GOAWAY时，对端未处理的虚拟连接以 ErrGoAway 失败
+func TestMultiplexer_GoAwayRefused(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	_, err = multiplexer.Ping(context.Background())
+	assert.NoError(t, err)
+
+	// a virtual conn opened above the last stream id of the GOAWAY
+	m := multiplexer.(*Multiplexer)
+	late := virtualConn(context.Background(), 99, m.conn, m, true)
+	assert.NoError(t, m.virtualConns.Reg(99, late))
+	handleGoAway(m, &Msg{Type: MessageGoAway, Data: encodeGoAway(vc.(*VirtualConn).Id(), CodeNoError, "")})
+
+	_, err = late.Recv(context.Background())
+	assert.ErrorIs(t, err, ErrGoAway)
+	_, err = multiplexer.NewVirtualConn(context.Background())
+	assert.ErrorIs(t, err, ErrGoAway)
+	assert.NoError(t, vc.CloseSend())
+}
@@ -0,0 +1,182 @@
+package mux
+
+import (
+	"io"
+	"sync"
+
+	"github.com/orbit-w/mux-go/metadata"
+)
+
+/*
+   @Author: orbit-w
+   @File: header
+   @2026 10月 周六 22:10
+*/
+
+// header holds the response metadata of a virtual conn. On the side that accepted the
+// virtual conn it is the metadata still to be sent, on the side that opened it the
+// metadata received from the peer.
+// header 虚拟连接的响应元数据，接收方为待发送的元数据，发起方为收到的元数据
+type header struct {
+	mu      sync.Mutex
+	header  metadata.MD
+	trailer metadata.MD
+	status  error         //the status of the Fin frame, kept when the peer half-closed before it
+	sent    bool          //the header was sent, or the first message went out without one
+	once    sync.Once     //closes done
+	done    chan struct{} //closed when the header, the first message or the end of the virtual conn arrives
+}
+
+// SetHeader merges md into the header sent before the first message
+func (vc *VirtualConn) SetHeader(md metadata.MD) error {
+	vc.hdr.mu.Lock()
+	defer vc.hdr.mu.Unlock()
+	if vc.hdr.sent {
+		return ErrHeaderSent
+	}
+	vc.hdr.header = merge(vc.hdr.header, md)
+	return nil
+}
+
+// SendHeader merges md into the header and sends it at once, it can be called at most once
+func (vc *VirtualConn) SendHeader(md metadata.MD) error {
+	if err := vc.SetHeader(md); err != nil {
+		return err
+	}
+	return vc.flushHeader(true)
+}
+
+// SetTrailer merges md into the trailer sent along with the status when the handler returns
+func (vc *VirtualConn) SetTrailer(md metadata.MD) {
+	vc.hdr.mu.Lock()
+	vc.hdr.trailer = merge(vc.hdr.trailer, md)
+	vc.hdr.mu.Unlock()
+}
+
+// Header blocks until the peer sends the header, the first message or finishes the virtual conn
+func (vc *VirtualConn) Header() (metadata.MD, error) {
+	<-vc.hdr.done
+	vc.hdr.mu.Lock()
+	md := vc.hdr.header
+	vc.hdr.mu.Unlock()
+	if md == nil {
+		if err := vc.rb.GetErr(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+// Trailer returns the trailer sent by the peer, it is only available once Recv returned an error
+func (vc *VirtualConn) Trailer() metadata.MD {
+	vc.hdr.mu.Lock()
+	defer vc.hdr.mu.Unlock()
+	return vc.hdr.trailer
+}
+
+// flushHeader sends the pending header ahead of the first message,
+// force sends it even if it is empty
+func (vc *VirtualConn) flushHeader(force bool) error {
+	vc.hdr.mu.Lock()
+	defer vc.hdr.mu.Unlock()
+	if vc.hdr.sent {
+		return nil
+	}
+	vc.hdr.sent = true
+	if len(vc.hdr.header) == 0 && !force {
+		return nil
+	}
+	data, err := metadata.Marshal(vc.hdr.header)
+	if err != nil {
+		return err
+	}
+	return vc.mux.sched.pushInOrder(vc.Id(), vc.codec.Encode(&Msg{
+		Type: MessageHeader,
+		Id:   vc.Id(),
+		Data: data,
+	}))
+}
+
+// takeTrailer returns the trailer to be sent in the Fin frame
+func (vc *VirtualConn) takeTrailer() []byte {
+	vc.hdr.mu.Lock()
+	defer vc.hdr.mu.Unlock()
+	if len(vc.hdr.trailer) == 0 {
+		return nil
+	}
+	data, _ := metadata.Marshal(vc.hdr.trailer)
+	return data
+}
+
+// onHeader receives the header sent by the peer
+func (vc *VirtualConn) onHeader(data []byte) {
+	md := metadata.MD{}
+	if err := metadata.Unmarshal(data, &md); err != nil {
+		return
+	}
+	vc.hdr.mu.Lock()
+	vc.hdr.header = md
+	vc.hdr.mu.Unlock()
+	vc.headerDone()
+}
+
+// onTrailer receives the trailer sent by the peer in the Fin frame
+func (vc *VirtualConn) onTrailer(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	md := metadata.MD{}
+	if err := metadata.Unmarshal(data, &md); err != nil {
+		return
+	}
+	vc.hdr.mu.Lock()
+	vc.hdr.trailer = md
+	vc.hdr.mu.Unlock()
+}
+
+// onStatus records the status the peer finished the virtual conn with, the receiving
+// direction may already be closed by the peer's CloseSend
+func (vc *VirtualConn) onStatus(err error) {
+	if err == io.EOF {
+		return
+	}
+	vc.hdr.mu.Lock()
+	vc.hdr.status = err
+	vc.hdr.mu.Unlock()
+}
+
+// recvErr replaces the end of the virtual conn with the status the peer sent after it
+func (vc *VirtualConn) recvErr(err error) error {
+	if err != io.EOF {
+		return err
+	}
+	vc.hdr.mu.Lock()
+	defer vc.hdr.mu.Unlock()
+	if vc.hdr.status != nil {
+		return vc.hdr.status
+	}
+	return err
+}
+
+// headerDone wakes up Header, the header can no longer arrive
+func (vc *VirtualConn) headerDone() {
+	vc.hdr.once.Do(func() {
+		close(vc.hdr.done)
+	})
+}
+
+func handleHeader(mux *Multiplexer, in *Msg) {
+	if v, ok := mux.virtualConns.Get(in.Id); ok {
+		v.onHeader(in.Data)
+	}
+}
+
+func merge(dst, src metadata.MD) metadata.MD {
+	if dst == nil {
+		dst = metadata.MD{}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,82 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/orbit-w/mux-go/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: header_test
+   @2026 10月 周六 22:40
+*/
+
+func TestVirtualConn_HeaderTrailer(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return err
+		}
+		switch string(in) {
+		case "send header":
+			assert.NoError(t, conn.SetHeader(metadata.MD{"codec": "json"}))
+			assert.NoError(t, conn.SendHeader(metadata.MD{"session": "s-1"}))
+			assert.ErrorIs(t, conn.SendHeader(nil), ErrHeaderSent)
+		case "set header":
+			assert.NoError(t, conn.SetHeader(metadata.MD{"codec": "json"}))
+			assert.NoError(t, conn.Send([]byte("reply")))
+			assert.ErrorIs(t, conn.SetHeader(metadata.MD{"late": true}), ErrHeaderSent)
+		}
+		conn.SetTrailer(metadata.MD{"count": 1})
+		conn.SetTrailer(metadata.MD{"cost": "1ms"})
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	// the header is sent explicitly
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("send header")))
+	md, err := vc.Header()
+	assert.NoError(t, err)
+	v, _ := md.GetString("session")
+	assert.Equal(t, "s-1", v)
+	v, _ = md.GetString("codec")
+	assert.Equal(t, "json", v)
+	_, err = vc.Recv(context.Background())
+	assert.Equal(t, io.EOF, err)
+	trailer := vc.Trailer()
+	count, _ := trailer.GetInt("count")
+	assert.Equal(t, 1, count)
+	v, _ = trailer.GetString("cost")
+	assert.Equal(t, "1ms", v)
+
+	// the header goes ahead of the first message
+	vc, err = multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("set header")))
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "reply", string(in))
+	md, err = vc.Header()
+	assert.NoError(t, err)
+	v, _ = md.GetString("codec")
+	assert.Equal(t, "json", v)
+
+	// no header at all
+	vc, err = multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("none")))
+	md, err = vc.Header()
+	assert.NoError(t, err)
+	assert.Nil(t, md)
+}
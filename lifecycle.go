@@ -0,0 +1,68 @@
+package mux
+
+import (
+	"sync"
+)
+
+/*
+   @Author: orbit-w
+   @File: lifecycle
+   @2026 10月 周日 17:30
+*/
+
+// lifecycle lets the owners of a multiplexer observe when and why it closed
+// lifecycle 记录多路复用器的关闭时机与原因
+type lifecycle struct {
+	closeMu  sync.Mutex
+	done     chan struct{}     //closed by recvLoop once the multiplexer is closed
+	cause    error             //the cause the multiplexer closed with, set before done is closed
+	onClose  []func(err error) //callbacks registered before the close
+	isClosed bool
+}
+
+// Done is closed once the physical connection is closed and all virtual conns are done
+func (mux *Multiplexer) Done() <-chan struct{} {
+	return mux.done
+}
+
+// Err returns the cause the multiplexer closed with:
+// ErrMuxClosed after Close, io.EOF when the peer closed the physical connection,
+// ErrKeepaliveTimeout, ErrGoAway, decode or transport errors otherwise. It is nil while running.
+// Err 返回多路复用器关闭的原因，运行中返回nil
+func (mux *Multiplexer) Err() error {
+	select {
+	case <-mux.done:
+		return mux.cause
+	default:
+		return nil
+	}
+}
+
+// OnClose registers f to be called with the close cause once the multiplexer is closed,
+// if it is already closed f is called at once. f must not block.
+// OnClose 注册关闭回调，多路复用器已关闭时立即调用，f 不能阻塞
+func (mux *Multiplexer) OnClose(f func(err error)) {
+	mux.closeMu.Lock()
+	if !mux.isClosed {
+		mux.onClose = append(mux.onClose, f)
+		mux.closeMu.Unlock()
+		return
+	}
+	mux.closeMu.Unlock()
+	f(mux.cause)
+}
+
+// closed records the close cause, wakes up Done and runs the callbacks
+func (mux *Multiplexer) closed(cause error) {
+	mux.closeMu.Lock()
+	mux.cause = cause
+	mux.isClosed = true
+	callbacks := mux.onClose
+	mux.onClose = nil
+	close(mux.done)
+	mux.closeMu.Unlock()
+
+	for _, f := range callbacks {
+		f(cause)
+	}
+}
@@ -0,0 +1,69 @@
+package mux
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: lifecycle_test
+   @2026 10月 周日 17:50
+*/
+
+func TestMultiplexer_Lifecycle(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	assert.NoError(t, multiplexer.Err())
+
+	causes := make(chan error, 2)
+	multiplexer.OnClose(func(err error) {
+		causes <- err
+	})
+
+	multiplexer.Close()
+	select {
+	case <-multiplexer.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("mux not done")
+	}
+	assert.ErrorIs(t, multiplexer.Err(), ErrMuxClosed)
+	assert.ErrorIs(t, <-causes, ErrMuxClosed)
+
+	// registered after the close, called at once
+	multiplexer.OnClose(func(err error) {
+		causes <- err
+	})
+	assert.ErrorIs(t, <-causes, ErrMuxClosed)
+}
+
+// 物理连接被对端关闭时，Done 立即关闭，Err 返回真实原因
+func TestMultiplexer_LifecyclePeerClosed(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		return nil
+	})
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	_, err := multiplexer.Ping(context.Background())
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Stop())
+	select {
+	case <-multiplexer.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("mux not done")
+	}
+	assert.Error(t, multiplexer.Err())
+	assert.NotErrorIs(t, multiplexer.Err(), ErrMuxClosed)
+}
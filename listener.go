@@ -0,0 +1,84 @@
+package mux
+
+import (
+	"context"
+	"net"
+)
+
+/*
+   @Author: orbit-w
+   @File: listener
+   @2026 10月 周日 15:20
+*/
+
+// Accept waits for the next virtual conn opened by a client, it is only available when the
+// server was started without a handler (ServeByConfig(addr, nil, conf)).
+// The caller owns the virtual conn and must Close it when done.
+// Accept 等待客户端发起的下一个虚拟连接，仅在未设置处理函数启动服务时可用，调用方需负责关闭虚拟连接
+func (s *Server) Accept(ctx context.Context) (IServerConn, error) {
+	if s.acceptCh == nil {
+		return nil, ErrAcceptDisabled
+	}
+	select {
+	case conn := <-s.acceptCh:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.ctx.Done():
+		return nil, ErrServerClosed
+	}
+}
+
+// acceptLoop is the handler of a server in accept mode, it hands the virtual conn over to Accept
+// and keeps it open until the caller of Accept or the client finishes it
+func (s *Server) acceptLoop(conn IServerConn) error {
+	select {
+	case s.acceptCh <- conn:
+	case <-conn.Context().Done():
+		return nil
+	case <-s.ctx.Done():
+		return ErrServerClosed
+	}
+	<-conn.Context().Done()
+	return nil
+}
+
+// Listener returns a net.Listener view of a server in accept mode, every accepted
+// virtual conn is wrapped by NewNetConn, closing the listener stops the server.
+// Listener 返回 net.Listener 视图，每个虚拟连接以 net.Conn 的形式返回，可直接用于 http.Server.Serve 等
+func (s *Server) Listener() net.Listener {
+	return &listener{s: s}
+}
+
+type listener struct {
+	s *Server
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.s.Accept(context.Background())
+	if err != nil {
+		if err == ErrServerClosed {
+			return nil, net.ErrClosed
+		}
+		return nil, err
+	}
+	return NewNetConn(conn), nil
+}
+
+func (l *listener) Close() error {
+	return l.s.Stop()
+}
+
+func (l *listener) Addr() net.Addr {
+	return listenAddr(l.s.Addr())
+}
+
+type listenAddr string
+
+func (a listenAddr) Network() string {
+	return "mux"
+}
+
+func (a listenAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,109 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: listener_test
+   @2026 10月 周日 15:40
+*/
+
+func TestServer_Accept(t *testing.T) {
+	s := serveWithHandler(t, Dev, nil)
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("ping")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	sc, err := s.Accept(ctx)
+	assert.NoError(t, err)
+	in, err := sc.Recv(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(in))
+
+	// the accepted virtual conn stays open until it is closed
+	assert.NoError(t, sc.Send([]byte("pong")))
+	in, err = vc.Recv(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "pong", string(in))
+	sc.Close()
+	_, err = vc.Recv(ctx)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// no virtual conn pending
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel2()
+	_, err = s.Accept(ctx2)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestServer_AcceptDisabled(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		return nil
+	})
+	defer s.Stop()
+	_, err := s.Accept(context.Background())
+	assert.ErrorIs(t, err, ErrAcceptDisabled)
+}
+
+func TestServer_Listener(t *testing.T) {
+	s := serveWithHandler(t, Dev, nil)
+	ln := s.Listener()
+	assert.Equal(t, s.Addr(), ln.Addr().String())
+
+	hs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	})}
+	served := make(chan error, 1)
+	go func() {
+		served <- hs.Serve(ln)
+	}()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			vc, err := multiplexer.NewVirtualConn(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return NewNetConn(vc), nil
+		},
+	}}
+	for _, path := range []string{"/a", "/b"} {
+		resp, err := client.Get("http://mux" + path)
+		assert.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, "hello "+path, string(body))
+	}
+	client.CloseIdleConnections()
+
+	assert.NoError(t, ln.Close())
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(time.Second * 5):
+		t.Fatal("http server did not stop")
+	}
+}
@@ -1,5 +1,7 @@
 package multiplexers
 
+import "crypto/tls"
+
 /*
    @Author: orbit-w
    @File: config
@@ -7,8 +9,9 @@ package multiplexers
 */
 
 type Config struct {
-	MuxMaxConns int //每个mux最大虚拟连接数
-	MuxCount    int //常驻mux数量
+	MuxMaxConns int         //每个mux最大虚拟连接数
+	MuxCount    int         //常驻mux数量
+	TLSConfig   *tls.Config //不为nil时通过 mux.DialTLS 建立TLS物理连接
 }
 
 func DefaultConfig() *Config {
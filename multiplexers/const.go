@@ -1,5 +1,7 @@
 package multiplexers
 
+import "time"
+
 /*
    @Author: orbit-w
    @File: const
@@ -11,3 +13,7 @@ const (
 	MuxCount          = 5
 	MaxIncomingPacket = 1<<18 - 1
 )
+
+// RedialInterval the delay before redialing a multiplexer that never completed the handshake,
+// so an unreachable server is not redialed in a busy loop
+const RedialInterval = time.Second //未完成握手即关闭的多路复用器，间隔该时间后重新拨号
@@ -2,8 +2,11 @@ package multiplexers
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/orbit-w/meteor/modules/net/transport"
 	"github.com/orbit-w/mux-go"
@@ -21,7 +24,9 @@ type Multiplexers struct {
 	muxCount     int //mux的数量
 	maxConns     int //mux对应的最大虚拟连接数
 	host         string
+	tlsConfig    *tls.Config
 	balancer     *Balancer
+	rw           sync.RWMutex
 	multiplexers []mux.IMux
 	tempConns    *connCache
 }
@@ -33,6 +38,7 @@ func New(host string, conf *Config) *Multiplexers {
 
 	m := &Multiplexers{
 		host:      host,
+		tlsConfig: conf.TLSConfig,
 		muxCount:  conf.MuxCount,
 		maxConns:  conf.MuxMaxConns,
 		tempConns: newConnCache(),
@@ -59,13 +65,86 @@ func NewWithDefaultConf(host string) *Multiplexers {
 
 func (m *Multiplexers) init() {
 	for i := 0; i < m.muxCount; i++ {
-		ctx := context.Background()
-		conn := transport.DialContextWithOps(ctx, m.host, &transport.DialOption{
-			MaxIncomingPacket: MaxIncomingPacket,
-		})
-		multiplexer := mux.NewMultiplexer(ctx, conn, mux.NewClientConfig(m.maxConns))
+		// a failed dial leaves a closed multiplexer, it is redialed by Dial
+		multiplexer, _ := m.dial()
 		m.multiplexers = append(m.multiplexers, multiplexer)
+		m.watch(i, multiplexer)
+	}
+}
+
+// watch replaces the multiplexer at index as soon as it is closed, instead of leaving it to
+// the next Dial to find it dead. One that never completed the handshake is redialed after
+// RedialInterval. Nothing is redialed once the Multiplexers are closed.
+// 多路复用器关闭后立即在后台重新拨号，未完成握手的间隔 RedialInterval 后重试
+func (m *Multiplexers) watch(index int, multiplexer mux.IMux) {
+	multiplexer.OnClose(func(error) {
+		var delay time.Duration
+		if _, ok := multiplexer.PeerSettings(); !ok {
+			delay = RedialInterval
+		}
+		time.AfterFunc(delay, func() {
+			_, _ = m.replace(index, multiplexer)
+		})
+	})
+}
+
+// dial opens a multiplexer, when the TLS dial fails the multiplexer returned along with
+// the error is already closing with the dial error
+func (m *Multiplexers) dial() (mux.IMux, error) {
+	conn, err := m.dialConn()
+	conf := mux.NewClientConfig(m.maxConns)
+	conf.MaxFrameSize = MaxIncomingPacket
+	return mux.NewMultiplexer(context.Background(), conn, conf), err
+}
+
+// dialConn opens a physical connection, with TLS if configured. A failed TLS dial gives
+// a connection that fails at once.
+func (m *Multiplexers) dialConn() (mux.TransportConn, error) {
+	ctx := context.Background()
+	if m.tlsConfig == nil {
+		return transport.DialContextWithOps(ctx, m.host, &transport.DialOption{
+			MaxIncomingPacket: MaxIncomingPacket,
+		}), nil
 	}
+	ctx, cancel := context.WithTimeout(ctx, mux.DialTimeout)
+	defer cancel()
+	conn, err := mux.DialTLS(ctx, m.host, m.tlsConfig)
+	if err != nil {
+		return failedConn{err: err}, err
+	}
+	return conn, nil
+}
+
+func (m *Multiplexers) get(index int) mux.IMux {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	return m.multiplexers[index]
+}
+
+// replace swaps a multiplexer that is going away or already closed for a fresh one,
+// the old one closes itself once its virtual conns are done. The fresh one is dialed
+// without holding the lock, so the other multiplexers stay available meanwhile.
+// 替换正在优雅关闭或已关闭的多路复用器，旧的多路复用器在其虚拟连接全部结束后自行关闭；
+// 拨号时不持有锁，其他多路复用器不受影响
+func (m *Multiplexers) replace(index int, old mux.IMux) (mux.IMux, error) {
+	if cur := m.get(index); cur != old || m.state.Load() != StateNone {
+		return cur, nil
+	}
+
+	fresh, err := m.dial()
+
+	m.rw.Lock()
+	cur := m.multiplexers[index]
+	if cur != old || m.state.Load() != StateNone {
+		// replaced by another goroutine or closed meanwhile
+		m.rw.Unlock()
+		fresh.Close()
+		return cur, nil
+	}
+	m.multiplexers[index] = fresh
+	m.rw.Unlock()
+	m.watch(index, fresh)
+	return fresh, err
 }
 
 func (m *Multiplexers) State() int32 {
@@ -76,8 +155,24 @@ func (m *Multiplexers) State() int32 {
 func (m *Multiplexers) Dial(ctx context.Context) (IConn, error) {
 	index := m.balancer.Next()
 
-	multiplexer := m.multiplexers[index]
+	multiplexer := m.get(index)
+	select {
+	case <-multiplexer.Done():
+		// the physical connection broke and watch has not replaced it yet, redial before opening the virtual conn
+		var err error
+		if multiplexer, err = m.replace(index, multiplexer); err != nil {
+			return nil, err
+		}
+	default:
+	}
 	vc, err := multiplexer.NewVirtualConn(ctx)
+	if errors.Is(err, mux.ErrGoAway) {
+		// the server is draining this physical connection, retry on a fresh one
+		if multiplexer, err = m.replace(index, multiplexer); err != nil {
+			return nil, err
+		}
+		vc, err = multiplexer.NewVirtualConn(ctx)
+	}
 	if err != nil {
 		if !errors.Is(err, mux.ErrVirtualConnUpLimit) {
 			return nil, err
@@ -114,10 +209,12 @@ func (m *Multiplexers) Close() {
 		return
 	}
 
+	m.rw.RLock()
 	for i := range m.multiplexers {
 		multiplexer := m.multiplexers[i]
 		multiplexer.Close()
 	}
+	m.rw.RUnlock()
 
 	// Iterate through the temporary map, closing each virtual connection
 	m.tempConns.OnClose(func(conn IConn) {
@@ -128,10 +225,13 @@ func (m *Multiplexers) Close() {
 func (m *Multiplexers) newTempConn() (IConn, error) {
 	// All multiplexers are at limit, create a new one
 	ctx := context.Background()
-	conn := transport.DialContextWithOps(ctx, m.host, &transport.DialOption{
-		MaxIncomingPacket: MaxIncomingPacket,
-	})
-	multiplexer := mux.NewMultiplexer(ctx, conn)
+	conf := mux.DefaultClientConfig()
+	conf.MaxFrameSize = MaxIncomingPacket
+	conn, err := m.dialConn()
+	if err != nil {
+		return nil, err
+	}
+	multiplexer := mux.NewMultiplexer(ctx, conn, conf)
 	vc, err := multiplexer.NewVirtualConn(ctx)
 	if err != nil {
 		multiplexer.Close()
@@ -153,3 +253,20 @@ func (m *Multiplexers) newTempConn() (IConn, error) {
 func (m *Multiplexers) connId() int64 {
 	return m.connIdx.Add(1)
 }
+
+// failedConn is the physical connection of a failed dial
+type failedConn struct {
+	err error
+}
+
+func (c failedConn) Send([]byte) error {
+	return c.err
+}
+
+func (c failedConn) Recv(context.Context) ([]byte, error) {
+	return nil, c.err
+}
+
+func (c failedConn) Close() error {
+	return nil
+}
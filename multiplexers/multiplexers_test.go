@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -12,6 +13,7 @@ import (
 
 	pq "github.com/orbit-w/meteor/bases/container/priority_queue"
 	"github.com/orbit-w/mux-go"
+	"github.com/orbit-w/mux-go/muxtest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -225,3 +227,202 @@ func serveWithHandler(t assert.TestingT, stage string, recvHandler func(conn mux
 func Test_Decr(t *testing.T) {
 	fmt.Println(^uint64(0))
 }
+
+// 服务端GOAWAY后，Dial 会用新的多路复用器替换正在排空的多路复用器
+func TestMultiplexers_GoAway(t *testing.T) {
+	server := serveWithHandler(t, Dev, func(conn mux.IServerConn) error {
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				return nil
+			}
+			_ = conn.Send(in)
+		}
+	})
+	defer server.Stop()
+
+	mus := New(server.Addr(), &Config{MuxMaxConns: 10, MuxCount: 1})
+	defer mus.Close()
+
+	conn, err := mus.Dial(context.Background())
+	assert.NoError(t, err)
+	old := mus.get(0)
+
+	server.GoAway()
+	time.Sleep(time.Millisecond * 100)
+
+	conn2, err := mus.Dial(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, old != mus.get(0))
+	assert.NoError(t, conn2.Send([]byte("hello")))
+	in, err := conn2.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, conn2.Close())
+}
+
+// 物理连接断开后，Dial 重新建立多路复用器
+func TestMultiplexers_Redial(t *testing.T) {
+	server := serveWithHandler(t, Dev, func(conn mux.IServerConn) error {
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				return nil
+			}
+			_ = conn.Send(in)
+		}
+	})
+	defer server.Stop()
+
+	mus := New(server.Addr(), &Config{MuxMaxConns: 10, MuxCount: 1})
+	defer mus.Close()
+
+	old := mus.get(0)
+	old.Close()
+	<-old.Done()
+
+	conn, err := mus.Dial(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, old != mus.get(0))
+	assert.NoError(t, conn.Send([]byte("hello")))
+	in, err := conn.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+	assert.NoError(t, conn.Close())
+}
+
+// 物理连接断开后立即在后台重新建立多路复用器，无需等待下一次 Dial
+func TestMultiplexers_RedialOnClose(t *testing.T) {
+	server := serveWithHandler(t, Dev, func(conn mux.IServerConn) error {
+		<-conn.Context().Done()
+		return nil
+	})
+	defer server.Stop()
+
+	mus := New(server.Addr(), &Config{MuxMaxConns: 10, MuxCount: 1})
+	defer mus.Close()
+
+	old := mus.get(0)
+	old.Close()
+	assert.Eventually(t, func() bool {
+		return mus.get(0) != old
+	}, time.Second*5, time.Millisecond*5)
+	fresh := mus.get(0)
+	assert.Eventually(t, func() bool {
+		_, ok := fresh.PeerSettings()
+		return ok
+	}, time.Second*5, time.Millisecond*5)
+}
+
+// 关闭连接池后不再重新拨号
+func TestMultiplexers_NoRedialAfterClose(t *testing.T) {
+	server := serveWithHandler(t, Dev, func(conn mux.IServerConn) error {
+		<-conn.Context().Done()
+		return nil
+	})
+	defer server.Stop()
+
+	mus := New(server.Addr(), &Config{MuxMaxConns: 10, MuxCount: 1})
+	old := mus.get(0)
+	mus.Close()
+	<-old.Done()
+	assert.Never(t, func() bool {
+		return mus.get(0) != old
+	}, time.Millisecond*200, time.Millisecond*10)
+}
+
+// 配置 TLSConfig 后通过TLS建立物理连接
+func TestMultiplexers_TLS(t *testing.T) {
+	serverTLS, clientTLS := muxtest.TLSConfigs(t, "client")
+	conf := mux.DefaultServerConfig()
+	conf.TLSConfig = serverTLS
+	server := new(mux.Server)
+	assert.NoError(t, server.ServeByConfig("127.0.0.1:0", func(conn mux.IServerConn) error {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return nil
+		}
+		return conn.Send(append(in, []byte(" "+conn.TLSConnectionState().PeerCertificates[0].Subject.CommonName)...))
+	}, conf))
+	defer server.Stop()
+
+	mus := New(server.Addr(), &Config{MuxMaxConns: 10, MuxCount: 1, TLSConfig: clientTLS})
+	defer mus.Close()
+
+	conn, err := mus.Dial(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Send([]byte("hello")))
+	in, err := conn.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello client", string(in))
+	assert.NoError(t, conn.Close())
+}
+
+// TLS握手失败的多路复用器立即关闭，下一次 Dial 重新建立
+func TestMultiplexers_TLSDialFailed(t *testing.T) {
+	_, clientTLS := muxtest.TLSConfigs(t, "client")
+	mus := New("127.0.0.1:1", &Config{MuxMaxConns: 10, MuxCount: 1, TLSConfig: clientTLS})
+	defer mus.Close()
+
+	old := mus.get(0)
+	<-old.Done()
+	assert.Error(t, old.Err())
+	_, err := mus.Dial(context.Background())
+	assert.Error(t, err)
+	assert.True(t, old != mus.get(0))
+}
+
+// 重新拨号时不持有锁，其他多路复用器仍可使用
+func TestMultiplexers_ReplaceDialsUnlocked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	stalled := make(chan net.Conn, 1)
+	go func() {
+		for i := 0; ; i++ {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if i < 2 {
+				// the initial dials fail at once
+				_ = c.Close()
+				continue
+			}
+			// the redial hangs in the TLS handshake
+			stalled <- c
+		}
+	}()
+
+	_, clientTLS := muxtest.TLSConfigs(t, "client")
+	mus := New(ln.Addr().String(), &Config{MuxMaxConns: 10, MuxCount: 2, TLSConfig: clientTLS})
+	defer mus.Close()
+
+	replaced := make(chan error, 1)
+	go func() {
+		_, err := mus.replace(0, mus.get(0))
+		replaced <- err
+	}()
+
+	var c net.Conn
+	select {
+	case c = <-stalled:
+	case <-time.After(time.Second * 5):
+		t.Fatal("redial not started")
+	}
+	got := make(chan struct{})
+	go func() {
+		mus.get(1)
+		close(got)
+	}()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by the redial")
+	}
+
+	_ = c.Close()
+	assert.Error(t, <-replaced)
+}
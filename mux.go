@@ -2,12 +2,13 @@ package mux
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math"
+	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/orbit-w/meteor/bases/misc/utils"
-	"github.com/orbit-w/meteor/modules/net/packet"
-	"github.com/orbit-w/meteor/modules/net/transport"
 	"github.com/orbit-w/mux-go/metadata"
 )
 
@@ -24,94 +25,241 @@ import (
 // 也不会将用户阻塞在Recv上。
 type IMux interface {
 	NewVirtualConn(ctx context.Context) (IConn, error)
+
+	// Ping measures the round-trip time of the physical connection
+	// 中文：Ping 测量物理连接的往返时间
+	Ping(ctx context.Context) (time.Duration, error)
+
+	// RTT returns the smoothed round-trip time measured by Ping and keepalive
+	// 中文：RTT 返回由 Ping 和保活测得的平滑往返时间
+	RTT() time.Duration
+
+	// PeerSettings returns the settings announced by the peer in the handshake
+	// 中文：PeerSettings 返回对端在握手中通告的设置
+	PeerSettings() (Settings, bool)
+
+	// GoAway drains the multiplexer, see Multiplexer.GoAway
+	// 中文：GoAway 优雅关闭多路复用器，不再创建新的虚拟连接
+	GoAway() error
+
+	// Done is closed once the physical connection is closed and all virtual conns are done
+	// 中文：Done 在物理连接关闭、所有虚拟连接结束后关闭
+	Done() <-chan struct{}
+
+	// Err returns the cause the multiplexer closed with, nil while it is running
+	// 中文：Err 返回多路复用器关闭的原因，运行中返回nil
+	Err() error
+
+	// OnClose registers f to be called with the close cause, f is called at once if the multiplexer is already closed
+	// 中文：OnClose 注册关闭回调，已关闭时立即调用
+	OnClose(f func(err error))
+
 	Close()
 }
 
 type Multiplexer struct {
 	isClient     bool
 	state        atomic.Uint32
-	conn         transport.IConn
+	conn         TransportConn
 	codec        *Codec
 	virtualConns *VirtualConns
+	sched        *scheduler  //orders the outgoing frames, written by writeLoop
+	sendQuota    *writeQuota //connection-level send window
+	inFlow       *inFlow     //connection-level receive window
+	pinger       *pinger
 	ctx          context.Context
 	cancel       context.CancelFunc
+	errOnce      sync.Once
+	err          error //the error the virtual conns are closed with
+	lifecycle
+
+	local          Settings  //settings announced to the peer
+	peer           *Settings //settings announced by the peer, nil until the handshake completes
+	settingsMu     sync.RWMutex
+	maxFrameSize   atomic.Uint32 //the largest frame the peer accepts, 0 if unknown
+	maxMessageSize atomic.Uint32 //the largest message the peer reassembles, 0 if unknown
+	hs             handshake     //resolved by the first frame of the peer, see handshake
+
+	openMu      sync.Mutex  //allocating an id and queuing its Start frame is atomic, so the peer sees increasing ids
+	acceptMu    sync.Mutex  //serializes accepting peer virtual conns with sending GOAWAY
+	lastPeerId  int64       //the last virtual conn accepted from the peer
+	highPeerId  int64       //the highest virtual conn id opened by the peer, accepted or refused
+	localGoAway bool        //this side sent GOAWAY
+	goingAway   atomic.Bool //either side sent GOAWAY
+
+	authed   atomic.Bool //the client's credentials were accepted
+	identity any         //the identity returned by MuxServerConfig.Authenticate, written by the receiving goroutine before authed
 
 	conf   MuxClientConfig //client side config
 	server *Server         //server side
 }
 
-func NewMultiplexer(f context.Context, conn transport.IConn, ops ...MuxClientConfig) IMux {
+func NewMultiplexer(f context.Context, conn TransportConn, ops ...MuxClientConfig) IMux {
 	conf := parseConfig(ops...)
 	mux := newCliMultiplexer(f, conn, conf)
+	_ = mux.sendSettings()
+	mux.sendAuth()
+	go mux.writeLoop()
 	go mux.recvLoop()
+	go mux.keepaliveLoop(conf.KeepaliveInterval, conf.KeepaliveTimeout)
 	return mux
 }
 
-func newMultiplexer(f context.Context, conn transport.IConn, isClient bool, server *Server) *Multiplexer {
+func newMultiplexer(f context.Context, conn TransportConn, isClient bool, server *Server) *Multiplexer {
 	ctx, cancel := context.WithCancel(f)
 	mux := &Multiplexer{
 		isClient:     isClient,
 		conn:         conn,
-		virtualConns: newConns(0),
+		virtualConns: newConns(int(server.conf.MaxConcurrentStreams), isClient),
 		ctx:          ctx,
 		cancel:       cancel,
 		codec:        new(Codec),
+		pinger:       newPinger(),
+		sched:        newScheduler(),
+		local:        server.conf.toSettings(),
 		server:       server,
 	}
+	mux.initFlowControl()
+	mux.done = make(chan struct{})
 	return mux
 }
 
-func newCliMultiplexer(f context.Context, conn transport.IConn, conf MuxClientConfig) *Multiplexer {
+func newCliMultiplexer(f context.Context, conn TransportConn, conf MuxClientConfig) *Multiplexer {
 	ctx, cancel := context.WithCancel(f)
 	mux := &Multiplexer{
 		isClient:     true,
 		conn:         conn,
-		virtualConns: newConns(conf.MaxVirtualConns),
+		virtualConns: newConns(conf.maxVirtualConns(), true),
 		ctx:          ctx,
 		cancel:       cancel,
 		codec:        new(Codec),
+		pinger:       newPinger(),
+		sched:        newScheduler(),
+		local:        conf.toSettings(),
 		conf:         conf,
 	}
+	mux.initFlowControl()
+	mux.done = make(chan struct{})
 	return mux
 }
 
+func (mux *Multiplexer) initFlowControl() {
+	mux.hs.init()
+	mux.sendQuota = newWriteQuota(InitialConnWindowSize, mux.ctx.Done(), &mux.hs)
+	mux.inFlow = newInFlow(mux.local.InitialConnWindowSize,
+		flowLimit(mux.local.InitialConnWindowSize, InitialConnWindowSize, mux.local.MaxMessageSize))
+}
+
 func (mux *Multiplexer) NewVirtualConn(ctx context.Context) (IConn, error) {
+	if mux.isGoingAway() {
+		return nil, ErrGoAway
+	}
+
 	md, _ := metadata.FromOutContext(ctx)
+	var compressor Compressor
+	if name := compressorFromContext(ctx); name != "" {
+		if compressor = GetCompressor(name); compressor == nil {
+			return nil, ErrUnknownCompressor
+		}
+		md = metadata.New(md)
+		md.Set(CompressorKey, name)
+	}
 	data, err := metadata.Marshal(md)
 	if err != nil {
 		return nil, err
 	}
 
-	id := mux.virtualConns.Id()
-	vc := virtualConn(ctx, id, mux.conn, mux)
+	if !mux.isClient {
+		// only clients that announced FeatureServerPush in the handshake accept pushes
+		if err = mux.awaitHandshake(ctx); err != nil {
+			return nil, err
+		}
+		if peer, ok := mux.PeerSettings(); !ok || !peer.HasFeature(FeatureServerPush) {
+			return nil, ErrPushDisabled
+		}
+	}
+
+	wait, err := mux.waitsForAccept(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	if err = mux.virtualConns.Reg(id, vc); err != nil {
+	mux.openMu.Lock()
+	mux.settingsMu.RLock()
+	id := mux.virtualConns.Id()
+	if id > MaxStreamId {
+		mux.settingsMu.RUnlock()
+		mux.openMu.Unlock()
+		// the id space is exhausted, drain this physical connection so a fresh one takes over
+		_ = mux.GoAway()
+		return nil, ErrGoAway
+	}
+	vc := virtualConn(ctx, id, mux.conn, mux, true)
+	vc.weight.Store(uint32(weightFromContext(ctx)))
+	vc.compressor = compressor
+	err = mux.virtualConns.Reg(id, vc)
+	mux.settingsMu.RUnlock()
+	if err != nil {
+		mux.openMu.Unlock()
 		return nil, err
 	}
 
-	fp := mux.codec.Encode(&Msg{
+	// the Start frames are queued in the order of their ids, the peer refuses a decreasing id
+	err = mux.sendMsg(&Msg{
 		Type: MessageStart,
 		Id:   id,
 		Data: data,
 	})
-
-	defer packet.Return(fp)
-
-	if err = vc.conn.Send(fp.Data()); err != nil {
+	mux.openMu.Unlock()
+	if err != nil {
 		mux.virtualConns.Del(id)
+		mux.tryDrain()
 		return nil, newStreamBufSetErr(err)
 	}
+
+	if wait {
+		if err = vc.waitAccept(ctx); err != nil {
+			return nil, err
+		}
+	}
 	return vc, nil
 }
 
+// closeTimeout bounds the flush of the queued frames on Close, a peer that stops reading
+// must not keep the physical connection open
+const closeTimeout = time.Second * 3
+
+// Close closes the multiplexer, the frames already queued are flushed before
+// the physical connection is closed. The physical connection is closed anyway if
+// the flush does not complete within closeTimeout.
 func (mux *Multiplexer) Close() {
 	if mux.state.CompareAndSwap(StateMuxRunning, StateMuxStopped) {
+		mux.sched.close()
+		go mux.closeAfterFlush()
+	}
+}
+
+func (mux *Multiplexer) closeAfterFlush() {
+	t := time.NewTimer(closeTimeout)
+	defer t.Stop()
+	select {
+	case <-mux.Done():
+	case <-t.C:
+		// writeLoop is blocked in Send, closing the connection releases it and recvLoop
 		if mux.conn != nil {
 			_ = mux.conn.Close()
 		}
 	}
 }
 
+// closeWith closes the multiplexer, err is reported to the virtual conns as the cause
+func (mux *Multiplexer) closeWith(err error) {
+	mux.errOnce.Do(func() {
+		mux.err = err
+	})
+	mux.Close()
+}
+
 func (mux *Multiplexer) recvLoop() {
 	var (
 		in     []byte
@@ -121,10 +269,13 @@ func (mux *Multiplexer) recvLoop() {
 	)
 
 	defer func() {
+		closedLocally := mux.state.Load() == StateMuxStopped
 		mux.state.Store(StateMuxStopped)
 		if mux.conn != nil {
 			_ = mux.conn.Close()
 		}
+		mux.sched.close()
+		mux.cancel()
 
 		closeErr := ErrCancel
 		if err != nil {
@@ -132,9 +283,27 @@ func (mux *Multiplexer) recvLoop() {
 				closeErr = err
 			}
 		}
+		explicit := true
+		mux.errOnce.Do(func() {
+			explicit = false
+			mux.err = closeErr
+		})
+		closeErr = mux.err
 		mux.virtualConns.OnClose(func(stream *VirtualConn) {
 			stream.OnClose(closeErr)
 		})
+
+		cause := closeErr
+		if !explicit {
+			switch {
+			case closedLocally:
+				cause = ErrMuxClosed
+			case err != nil:
+				// io.EOF, decode and transport errors
+				cause = err
+			}
+		}
+		mux.closed(cause)
 	}()
 
 	var msg Msg
@@ -150,98 +319,286 @@ func (mux *Multiplexer) recvLoop() {
 			err = newDecodeErr(err)
 			return
 		}
+		if msg.Type != MessageSettings {
+			// the peers speaking the handshake send SETTINGS first
+			mux.hs.resolve(false)
+		}
 
 		handle(mux, &msg)
 	}
 }
 
-// loopVirtualConn
-// server side, recvLoop the virtual connection
-// 服务端侧，有新的虚拟链接进来，需要循环处理
+// acceptVirtualConn
+// the peer opened a new virtual connection, it is handled in its own goroutine:
+// on the server side by Server.handleLoop, on the client side (server push) by MuxClientConfig.AcceptHandler
+// 对端发起了新的虚拟链接，需要循环处理
 // 业务侧只需要break/return即可
-func (mux *Multiplexer) acceptVirtualConn(ctx context.Context, conn transport.IConn, id int64) {
-	vc := virtualConn(ctx, id, conn, mux)
-	_ = mux.virtualConns.Reg(id, vc)
+func (mux *Multiplexer) acceptVirtualConn(ctx context.Context, conn TransportConn, id int64, compressor Compressor) {
+	vc := virtualConn(ctx, id, conn, mux, false)
+	vc.compressor = compressor
+	if err := mux.virtualConns.Reg(id, vc); err != nil {
+		mux.sendReset(id, CodeRefusedStream, err.Error())
+		return
+	}
+	if !mux.isClient {
+		mux.server.handlerStart()
+	}
 	go mux.handleVirtualConn(vc)
 }
 
+func (mux *Multiplexer) acceptHandler() func(conn IServerConn) error {
+	if mux.isClient {
+		return mux.conf.AcceptHandler
+	}
+	return mux.server.handleLoop
+}
+
 func (mux *Multiplexer) handleVirtualConn(conn *VirtualConn) {
-	defer utils.RecoverPanic()
-	defer func() {
-		if _, exist := mux.virtualConns.GetAndDel(conn.Id()); exist {
-			err := conn.rb.GetErr()
-			if err == nil || err == io.EOF {
-				conn.sendToClientNtfFin()
-			}
-		}
+	if !mux.isClient {
+		defer mux.server.handlerDone()
+	}
+	if !mux.crashOnPanic() {
+		defer mux.recoverHandler(conn)
+	}
+	if mux.accept(conn) != nil {
+		return
+	}
 
-		// Close the stream
-		// Simultaneously disconnect the input and output of virtual connections
-		// 确保同时掐断虚拟连接的输入和输出
-		conn.OnClose(io.EOF)
-	}()
+	handle := mux.acceptHandler()
+	err := handle(conn)
+	// the error of the handler is sent to the peer as the status of the virtual conn
+	conn.finish(toStatus(err))
+}
+
+// onConnRead returns n bytes to the connection-level window
+func (mux *Multiplexer) onConnRead(n int) {
+	if n <= 0 {
+		return
+	}
+	if wu, _ := mux.inFlow.onRead(uint32(n)); wu > 0 {
+		mux.sendWindowUpdate(0, wu)
+	}
+}
+
+// sendWindowUpdate returns credit to the peer, id 0 refers to the whole connection
+func (mux *Multiplexer) sendWindowUpdate(id int64, n uint32) {
+	if mux.state.Load() != StateMuxRunning {
+		return
+	}
+	_ = mux.sendMsg(&Msg{
+		Type: MessageWindowUpdate,
+		Id:   id,
+		Data: encodeWindowUpdate(n),
+	})
+}
+
+// sendMsg queues a control frame, it is written ahead of the data of the virtual conns
+func (mux *Multiplexer) sendMsg(msg *Msg) error {
+	return mux.sched.pushControl(mux.codec.Encode(msg))
+}
+
+func (mux *Multiplexer) sendReset(id int64, code Code, reason string) {
+	_ = mux.sendMsg(&Msg{
+		Type: MessageRst,
+		Id:   id,
+		Data: encodeReset(code, reason),
+	})
+}
+
+func handleData(mux *Multiplexer, in *Msg) {
+	n := len(in.Data)
+	if n == 0 {
+		return
+	}
+	if _, err := mux.inFlow.onData(uint32(n), mux.hs.negotiated()); err != nil {
+		mux.connError(CodeFlowControlError, "connection window exceeded", err)
+		return
+	}
+	// the connection window only bounds the bytes in flight: the credit is returned as soon as
+	// the frame reaches its virtual conn, whose own window bounds what it buffers, so the
+	// virtual conns that are not read never hold back the others
+	mux.onConnRead(n)
+	if v, ok := mux.virtualConns.Get(in.Id); ok {
+		v.put(in.Data, in.Type == MessageFragment, in.Compressed)
+	}
+}
+
+// fragmentSize the largest payload of a data frame sent to the peer,
+// messages to legacy peers are not fragmented
+func (mux *Multiplexer) fragmentSize() int {
+	if !mux.hs.negotiated() {
+		return math.MaxInt
+	}
+	size := MaxFragmentSize
+	if max := int(mux.maxFrameSize.Load()) - maxHeaderLength; max > 0 && max < size {
+		size = max
+	}
+	return size
+}
 
-	handle := mux.server.handleLoop
-	if err := handle(conn); err != nil {
-		//TODO:
+func handleWindowUpdate(mux *Multiplexer, in *Msg) {
+	n, err := decodeWindowUpdate(in.Data)
+	if err != nil || n == 0 {
+		return
+	}
+	if in.Id == 0 {
+		mux.sendQuota.replenish(int(n))
+		return
+	}
+	if v, ok := mux.virtualConns.Get(in.Id); ok {
+		v.sendQuota.replenish(int(n))
 	}
 }
 
 func handleDataClientSide(mux *Multiplexer, in *Msg) {
 	switch in.Type {
-	case MessageRaw:
-		if len(in.Data) > 0 {
-			v, ok := mux.virtualConns.Get(in.Id)
-			if ok {
-				v.put(in.Data)
-			}
-		}
-	case MessageFin:
-		stream, ok := mux.virtualConns.GetAndDel(in.Id)
-		if ok {
-			stream.OnClose(io.EOF)
+	case MessageStart:
+		if mux.conf.AcceptHandler == nil {
+			//server push is not enabled on this side, refuse the virtual connection
+			mux.sendReset(in.Id, CodeRefusedStream, "server push disabled")
+			return
 		}
+		handleStart(mux, in)
+	default:
+		handleFrame(mux, in)
 	}
 }
 
 func handleDataServerSide(mux *Multiplexer, in *Msg) {
 	switch in.Type {
 	case MessageStart:
-		if mux.virtualConns.Exist(in.Id) {
-			return
-		}
+		handleStart(mux, in)
+	default:
+		handleFrame(mux, in)
+	}
+}
+
+func handleStart(mux *Multiplexer, in *Msg) {
+	mux.acceptMu.Lock()
+	defer mux.acceptMu.Unlock()
+	if !mux.checkPeerId(in.Id) {
+		mux.connError(CodeProtocolError, fmt.Sprintf("invalid stream id %d", in.Id), ErrProtocol)
+		return
+	}
+	if mux.requiresAuth() && !mux.authed.Load() {
+		mux.connError(CodeUnauthenticated, "virtual conn opened before authentication", ErrUnauthenticated)
+		return
+	}
+
+	md := metadata.MD{}
+	if err := metadata.Unmarshal(in.Data, &md); err != nil {
+		//remote close the virtual connection
+		mux.sendReset(in.Id, CodeProtocolError, "metadata unmarshal failed")
+		//mux.log.Error("[TcpServer] [func:handleStartFrame] metadata unmarshal failed", zap.Error(err))
+		return
+	}
+
+	if mux.localGoAway {
+		mux.sendReset(in.Id, CodeRefusedStream, "mux is going away")
+		return
+	}
 
-		md := metadata.MD{}
-		if err := metadata.Unmarshal(in.Data, &md); err != nil {
-			//remote close the virtual connection
-			pack := mux.codec.Encode(&Msg{
-				Type: MessageFin,
-				Id:   in.Id,
-			})
-			_ = mux.conn.Send(pack.Data())
-			packet.Return(pack)
-			//mux.log.Error("[TcpServer] [func:handleStartFrame] metadata unmarshal failed", zap.Error(err))
+	var compressor Compressor
+	if name, ok := md.GetString(CompressorKey); ok {
+		if compressor = GetCompressor(name); compressor == nil {
+			mux.sendReset(in.Id, CodeRefusedStream, "unknown compressor "+name)
 			return
 		}
+	}
+	if in.Id > mux.lastPeerId {
+		mux.lastPeerId = in.Id
+	}
 
-		ctx := metadata.NewIncomingContext(mux.ctx, md)
-		mux.acceptVirtualConn(ctx, mux.conn, in.Id)
+	ctx := metadata.NewIncomingContext(mux.ctx, md)
+	if mux.authed.Load() {
+		ctx = context.WithValue(ctx, identityKey{}, authIdentity{identity: mux.identity})
+	}
+	mux.acceptVirtualConn(ctx, mux.conn, in.Id, compressor)
+}
 
+// checkPeerId the ids of the virtual conns opened by the peer must grow monotonically, stay within
+// MaxStreamId and belong to the peer's half of the id space (odd for the client, even for the server).
+// A stale or duplicate id is a protocol error of the whole physical connection.
+// Only the ids of live virtual conns are refused for legacy peers.
+// 对端发起的虚拟连接ID必须单调递增、不超过 MaxStreamId 且奇偶性正确，否则为连接级协议错误；旧版本对端只校验ID未被占用
+func (mux *Multiplexer) checkPeerId(id int64) bool {
+	if id <= 0 || id > MaxStreamId {
+		return false
+	}
+	if !mux.hs.negotiated() {
+		if mux.virtualConns.Exist(id) {
+			return false
+		}
+		mux.highPeerId = max(mux.highPeerId, id)
+		return true
+	}
+	if id <= mux.highPeerId {
+		return false
+	}
+	if peerIsClient := id%2 == 1; peerIsClient == mux.isClient {
+		return false
+	}
+	mux.highPeerId = id
+	return true
+}
+
+// handleFrame handles the connection control frames and the frames of established
+// virtual connections, they are the same whichever side opened it
+func handleFrame(mux *Multiplexer, in *Msg) {
+	switch in.Type {
 	case MessageRaw:
-		streamId := in.Id
 		if in.End {
-			vc, ok := mux.virtualConns.Get(streamId)
+			vc, ok := mux.virtualConns.Get(in.Id)
 			if ok {
-				vc.OnClose(io.EOF)
+				vc.closeRecv(io.EOF)
 			}
 			return
 		}
 
-		if len(in.Data) > 0 {
-			v, ok := mux.virtualConns.Get(in.Id)
-			if ok {
-				v.put(in.Data)
+		handleData(mux, in)
+	case MessageFragment:
+		handleData(mux, in)
+	case MessageHeader:
+		handleHeader(mux, in)
+	case MessageAccept:
+		handleAccept(mux, in)
+	case MessageFin:
+		stream, ok := mux.virtualConns.GetAndDel(in.Id)
+		if ok {
+			var closeErr error = io.EOF
+			st, trailer, err := decodeStatus(in.Data)
+			if err != nil {
+				closeErr = &ResetError{Code: CodeProtocolError, Reason: "invalid status", Remote: true}
+			} else if st != nil {
+				closeErr = st
 			}
+			stream.onTrailer(trailer)
+			stream.onStatus(closeErr)
+			stream.OnClose(closeErr)
+			mux.tryDrain()
+		}
+	case MessageWindowUpdate:
+		handleWindowUpdate(mux, in)
+	case MessagePing:
+		handlePing(mux, in)
+	case MessagePong:
+		handlePong(mux, in)
+	case MessageGoAway:
+		handleGoAway(mux, in)
+	case MessageSettings:
+		handleSettings(mux, in)
+	case MessageAuth:
+		handleAuth(mux, in)
+	case MessageRst:
+		code, reason, err := decodeReset(in.Data)
+		if err != nil {
+			return
+		}
+		mux.sched.drop(in.Id)
+		stream, ok := mux.virtualConns.GetAndDel(in.Id)
+		if ok {
+			stream.OnClose(&ResetError{Code: code, Reason: reason, Remote: true})
+			mux.tryDrain()
 		}
 	}
 }
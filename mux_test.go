@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io"
 	"log"
+	"net"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -304,3 +305,218 @@ func Test_Serve(t *testing.T) {
 
 	assert.NoError(t, s.Stop())
 }
+
+// 服务端推送测试：服务端通过 IServerConn.Mux 主动向客户端发起虚拟连接
+func Test_ServerPush(t *testing.T) {
+	pushed := make(chan string, 1)
+	done := make(chan struct{})
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		defer close(done)
+		if _, err := conn.Recv(context.Background()); err != nil {
+			return err
+		}
+		push, err := conn.Mux().NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), push.(*VirtualConn).Id()%2)
+		assert.NoError(t, push.Send([]byte("hello, client")))
+		assert.NoError(t, push.CloseSend())
+		_, err = push.Recv(context.Background())
+		assert.Equal(t, io.EOF, err)
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, MuxClientConfig{
+		AcceptHandler: func(conn IServerConn) error {
+			in, err := conn.Recv(context.Background())
+			assert.NoError(t, err)
+			pushed <- string(in)
+			_, err = conn.Recv(context.Background())
+			assert.Equal(t, io.EOF, err)
+			return nil
+		},
+	})
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), vc.(*VirtualConn).Id()%2)
+	assert.NoError(t, vc.Send([]byte("hello, server")))
+
+	select {
+	case in := <-pushed:
+		assert.Equal(t, "hello, client", in)
+	case <-time.After(time.Second * 5):
+		t.Fatal("push stream not accepted")
+	}
+	<-done
+}
+
+// 客户端未开启服务端推送时，推送的虚拟连接会被拒绝
+func Test_ServerPushRefused(t *testing.T) {
+	refused := make(chan error, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		// the client announced in the handshake that it does not accept pushes
+		_, err := conn.Mux().NewVirtualConn(context.Background())
+		refused <- err
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	_, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	select {
+	case err = <-refused:
+		assert.Equal(t, ErrPushDisabled, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("push stream not refused")
+	}
+}
+
+func TestMultiplexer_CheckPeerId(t *testing.T) {
+	server := &Multiplexer{}
+	server.hs.settings.Store(true)
+	assert.True(t, server.checkPeerId(1))
+	assert.True(t, server.checkPeerId(7))
+	assert.False(t, server.checkPeerId(7), "duplicate")
+	assert.False(t, server.checkPeerId(5), "stale")
+	assert.False(t, server.checkPeerId(8), "server side id")
+	assert.False(t, server.checkPeerId(MaxStreamId+2), "out of range")
+	assert.True(t, server.checkPeerId(MaxStreamId))
+
+	client := &Multiplexer{isClient: true}
+	client.hs.settings.Store(true)
+	assert.False(t, client.checkPeerId(1), "client side id")
+	assert.True(t, client.checkPeerId(2))
+	assert.False(t, client.checkPeerId(0))
+
+	// no handshake: the ids of a legacy peer are neither partitioned nor ordered
+	legacy := &Multiplexer{virtualConns: newConns(0, false)}
+	assert.True(t, legacy.checkPeerId(2))
+	assert.True(t, legacy.checkPeerId(1))
+	assert.NoError(t, legacy.virtualConns.Reg(1, &VirtualConn{}))
+	assert.False(t, legacy.checkPeerId(1), "live id")
+	assert.False(t, legacy.checkPeerId(0))
+}
+
+// 对端重复使用虚拟连接ID为连接级协议错误，物理连接被关闭
+func TestMultiplexer_DuplicateStreamId(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	m := multiplexer.(*Multiplexer)
+	assert.NoError(t, m.sendMsg(&Msg{Type: MessageStart, Id: vc.(*VirtualConn).Id()}))
+
+	_, err = vc.Recv(context.Background())
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := multiplexer.Ping(context.Background())
+		return err == ErrMuxClosed
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// 并发创建虚拟连接时对端按递增顺序收到ID，物理连接不会因协议错误被关闭
+func TestMultiplexer_ConcurrentOpen(t *testing.T) {
+	conf := DevelopmentServerConfig()
+	conf.MaxConcurrentStreams = 100000
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		return nil
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, NewClientConfig(100000))
+	defer multiplexer.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				vc, err := multiplexer.NewVirtualConn(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				_ = vc.CloseSend()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the physical connection survived
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	if assert.NoError(t, err) {
+		_, err = vc.Recv(context.Background())
+		assert.ErrorIs(t, err, io.EOF)
+	}
+	assert.Nil(t, multiplexer.Err())
+}
+
+// ID耗尽后多路复用器进入GOAWAY，新的虚拟连接返回 ErrGoAway
+func TestMultiplexer_StreamIdExhausted(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	m := multiplexer.(*Multiplexer)
+	m.virtualConns.idx.Store(MaxStreamId - 2)
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, MaxStreamId, vc.(*VirtualConn).Id())
+
+	_, err = multiplexer.NewVirtualConn(context.Background())
+	assert.ErrorIs(t, err, ErrGoAway)
+
+	// the last virtual conn still completes, then the physical connection is drained
+	assert.NoError(t, vc.CloseSend())
+	_, err = vc.Recv(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Eventually(t, func() bool {
+		_, err := multiplexer.Ping(context.Background())
+		return err == ErrMuxClosed
+	}, time.Second*5, time.Millisecond*10)
+}
+
+// A peer that stops reading must not keep Close from closing the physical connection
+// 对端停止读取时，Close 在有限时间内关闭物理连接
+func TestMultiplexer_CloseStalledPeer(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	multiplexer := NewMultiplexer(context.Background(), NewFramedConn(a, 0))
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	// nobody reads the other end of the pipe, the writing goroutine blocks in Send
+	assert.NoError(t, vc.Send([]byte("hello")))
+
+	multiplexer.Close()
+	select {
+	case <-multiplexer.Done():
+	case <-time.After(closeTimeout * 2):
+		t.Fatal("physical connection not closed")
+	}
+}
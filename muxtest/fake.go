@@ -0,0 +1,401 @@
+package muxtest
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/orbit-w/mux-go"
+	"github.com/orbit-w/mux-go/metadata"
+)
+
+/*
+   @Author: orbit-w
+   @File: fake
+   @2026 10月 周日 21:40
+*/
+
+// stream is the in-memory virtual conn shared by the fakes: the test feeds the messages
+// Recv returns with Push and inspects what was sent with Sent
+type stream struct {
+	mu         sync.Mutex
+	in         [][]byte      //messages waiting for Recv
+	wake       chan struct{} //signaled when in changes or the receive direction ends
+	recvErr    error         //returned by Recv once in is empty, nil while open
+	sent       [][]byte
+	sendClosed bool
+	done       bool //closed or reset
+	reset      *mux.ResetError
+	rd, wd     time.Time
+	weight     uint8
+}
+
+func newStream() stream {
+	return stream{wake: make(chan struct{}, 1), weight: mux.DefaultWeight}
+}
+
+func (s *stream) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Push queues a message for Recv
+// Push 添加一条供 Recv 读取的消息
+func (s *stream) Push(data []byte) {
+	s.mu.Lock()
+	s.in = append(s.in, data)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// CloseRecv ends the receive direction, Recv returns err once the pushed messages are read,
+// nil means io.EOF
+// CloseRecv 结束接收方向，读完已添加的消息后 Recv 返回 err（nil 表示 io.EOF）
+func (s *stream) CloseRecv(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	s.mu.Lock()
+	if s.recvErr == nil {
+		s.recvErr = err
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Sent returns the messages sent so far
+// Sent 返回已发送的消息
+func (s *stream) Sent() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([][]byte, len(s.sent))
+	copy(out, s.sent)
+	return out
+}
+
+// SendClosed reports whether the send direction was closed by CloseSend or Close
+func (s *stream) SendClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sendClosed
+}
+
+// ResetErr returns the error the stream was reset with, nil if Reset was not called
+func (s *stream) ResetErr() *mux.ResetError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reset
+}
+
+func (s *stream) Send(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.sendClosed {
+		return mux.ErrConnDone
+	}
+	if !s.wd.IsZero() && !time.Now().Before(s.wd) {
+		return os.ErrDeadlineExceeded
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	s.sent = append(s.sent, buf)
+	return nil
+}
+
+func (s *stream) Recv(ctx context.Context) ([]byte, error) {
+	for {
+		s.mu.Lock()
+		if s.reset != nil {
+			s.mu.Unlock()
+			return nil, s.reset
+		}
+		if len(s.in) > 0 {
+			in := s.in[0]
+			s.in = s.in[1:]
+			if len(s.in) > 0 || s.recvErr != nil {
+				s.signal()
+			}
+			s.mu.Unlock()
+			return in, nil
+		}
+		if s.recvErr != nil {
+			err := s.recvErr
+			s.mu.Unlock()
+			s.signal()
+			return nil, err
+		}
+		rd := s.rd
+		s.mu.Unlock()
+
+		if err := s.wait(ctx, rd); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait blocks until the stream changes, the read deadline rd passes or ctx is done
+func (s *stream) wait(ctx context.Context, rd time.Time) error {
+	var timeout <-chan time.Time
+	if !rd.IsZero() {
+		dur := time.Until(rd)
+		if dur <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(dur)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-s.wake:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *stream) CloseSend() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.sendClosed {
+		return mux.ErrConnDone
+	}
+	s.sendClosed = true
+	return nil
+}
+
+func (s *stream) Reset(code mux.Code, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return mux.ErrConnDone
+	}
+	s.done = true
+	s.reset = &mux.ResetError{Code: code, Reason: reason}
+	s.signal()
+	return nil
+}
+
+func (s *stream) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.rd, s.wd = t, t
+	s.mu.Unlock()
+	s.signal()
+	return nil
+}
+
+func (s *stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.rd = t
+	s.mu.Unlock()
+	s.signal()
+	return nil
+}
+
+func (s *stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.wd = t
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *stream) LocalAddr() net.Addr {
+	return Addr("fake-local")
+}
+
+func (s *stream) RemoteAddr() net.Addr {
+	return Addr("fake-remote")
+}
+
+func (s *stream) SetWeight(weight uint8) {
+	s.mu.Lock()
+	s.weight = weight
+	s.mu.Unlock()
+}
+
+// Weight returns the weight set by SetWeight
+func (s *stream) Weight() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.weight
+}
+
+// ServerConn is a fake mux.IServerConn for unit-testing handlers without a multiplexer
+// ServerConn 用于单元测试处理函数的 mux.IServerConn 模拟实现
+type ServerConn struct {
+	stream
+	ctx        context.Context
+	cancel     context.CancelFunc
+	header     metadata.MD
+	headerSent bool
+	trailer    metadata.MD
+	closed     bool
+
+	// MuxImpl is returned by Mux, nil unless the test sets it
+	MuxImpl mux.IMux
+	// TLS is returned by TLSConnectionState, nil unless the test sets it
+	TLS *tls.ConnectionState
+}
+
+var _ mux.IServerConn = (*ServerConn)(nil)
+
+// NewServerConn returns a fake server conn, the incoming metadata of ctx is seen by the
+// handler through Context
+func NewServerConn(ctx context.Context) *ServerConn {
+	ctx, cancel := context.WithCancel(ctx)
+	return &ServerConn{
+		stream: newStream(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (c *ServerConn) Context() context.Context {
+	return c.ctx
+}
+
+func (c *ServerConn) SetHeader(md metadata.MD) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.headerSent {
+		return mux.ErrHeaderSent
+	}
+	c.header = merge(c.header, md)
+	return nil
+}
+
+func (c *ServerConn) SendHeader(md metadata.MD) error {
+	if err := c.SetHeader(md); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.headerSent = true
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ServerConn) SetTrailer(md metadata.MD) {
+	c.mu.Lock()
+	c.trailer = merge(c.trailer, md)
+	c.mu.Unlock()
+}
+
+// Send marks the header as sent like the first message of a real virtual conn
+func (c *ServerConn) Send(data []byte) error {
+	if err := c.stream.Send(data); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.headerSent = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Close ends both directions like a real virtual conn: Send fails with mux.ErrConnDone,
+// Recv returns the messages already pushed and then io.EOF
+func (c *ServerConn) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.done = true
+	c.sendClosed = true
+	if c.recvErr == nil {
+		c.recvErr = io.EOF
+	}
+	c.mu.Unlock()
+	c.signal()
+	c.cancel()
+}
+
+func (c *ServerConn) Reset(code mux.Code, reason string) error {
+	if err := c.stream.Reset(code, reason); err != nil {
+		return err
+	}
+	c.cancel()
+	return nil
+}
+
+func (c *ServerConn) Mux() mux.IMux {
+	return c.MuxImpl
+}
+
+func (c *ServerConn) TLSConnectionState() *tls.ConnectionState {
+	return c.TLS
+}
+
+// Header returns the header metadata set by the handler
+func (c *ServerConn) Header() metadata.MD {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header
+}
+
+// Trailer returns the trailer metadata set by the handler
+func (c *ServerConn) Trailer() metadata.MD {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.trailer
+}
+
+// Closed reports whether Close was called
+func (c *ServerConn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Conn is a fake mux.IConn for unit-testing the client side without a multiplexer
+// Conn 用于单元测试客户端逻辑的 mux.IConn 模拟实现
+type Conn struct {
+	stream
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+var _ mux.IConn = (*Conn)(nil)
+
+func NewConn() *Conn {
+	return &Conn{stream: newStream()}
+}
+
+// SetPeerHeader sets the header metadata returned by Header
+func (c *Conn) SetPeerHeader(md metadata.MD) {
+	c.mu.Lock()
+	c.header = md
+	c.mu.Unlock()
+}
+
+// SetPeerTrailer sets the trailer metadata returned by Trailer
+func (c *Conn) SetPeerTrailer(md metadata.MD) {
+	c.mu.Lock()
+	c.trailer = md
+	c.mu.Unlock()
+}
+
+func (c *Conn) Header() (metadata.MD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header, nil
+}
+
+func (c *Conn) Trailer() metadata.MD {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.trailer
+}
+
+func merge(dst, src metadata.MD) metadata.MD {
+	if dst == nil {
+		dst = metadata.MD{}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,119 @@
+package muxtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/mux-go"
+	"github.com/orbit-w/mux-go/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: fake_test
+   @2026 10月 周日 22:20
+*/
+
+// upper is the kind of handler a service unit-tests with ServerConn
+func upper(conn mux.IServerConn) error {
+	md, _ := metadata.FromIncomingContext(conn.Context())
+	if _, ok := md["user"]; !ok {
+		return mux.NewStatusError(mux.CodeRefusedStream, "no user")
+	}
+	_ = conn.SetHeader(metadata.MD{"handler": "upper"})
+	for {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			conn.SetTrailer(metadata.MD{"done": "true"})
+			return nil
+		}
+		if err = conn.Send([]byte(strings.ToUpper(string(in)))); err != nil {
+			return err
+		}
+	}
+}
+
+func TestServerConn(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{"user": "u1"})
+	conn := NewServerConn(ctx)
+	conn.Push([]byte("a"))
+	conn.Push([]byte("b"))
+	conn.CloseRecv(nil)
+
+	assert.NoError(t, upper(conn))
+	sent := conn.Sent()
+	assert.Len(t, sent, 2)
+	assert.Equal(t, "A", string(sent[0]))
+	assert.Equal(t, "B", string(sent[1]))
+	assert.Equal(t, "upper", conn.Header()["handler"])
+	assert.Equal(t, "true", conn.Trailer()["done"])
+
+	// the header is out once the first message was sent
+	assert.ErrorIs(t, conn.SetHeader(metadata.MD{"late": 1}), mux.ErrHeaderSent)
+
+	var se *mux.StatusError
+	assert.True(t, errors.As(upper(NewServerConn(context.Background())), &se))
+	assert.Equal(t, mux.CodeRefusedStream, se.Code)
+}
+
+func TestServerConn_Close(t *testing.T) {
+	conn := NewServerConn(context.Background())
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Millisecond*10)))
+	_, err := conn.Recv(context.Background())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	conn.Close()
+	assert.True(t, conn.Closed())
+	assert.ErrorIs(t, conn.Context().Err(), context.Canceled)
+	assert.ErrorIs(t, conn.Send([]byte("late")), mux.ErrConnDone)
+	assert.ErrorIs(t, conn.CloseSend(), mux.ErrConnDone)
+	assert.ErrorIs(t, conn.Reset(mux.CodeCancel, "late"), mux.ErrConnDone)
+	// like a real virtual conn, Recv reports the end of the stream
+	_, err = conn.Recv(context.Background())
+	assert.Equal(t, io.EOF, err)
+
+	// the messages pushed before Close are still read
+	conn = NewServerConn(context.Background())
+	conn.Push([]byte("pending"))
+	conn.Close()
+	in, err := conn.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", string(in))
+	_, err = conn.Recv(context.Background())
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestConn(t *testing.T) {
+	conn := NewConn()
+	conn.SetPeerHeader(metadata.MD{"h": "1"})
+	conn.Push([]byte("reply"))
+	conn.CloseRecv(mux.NewStatusError(mux.CodeUnknown, "failed"))
+
+	assert.NoError(t, conn.Send([]byte("request")))
+	assert.NoError(t, conn.CloseSend())
+	assert.True(t, conn.SendClosed())
+	assert.ErrorIs(t, conn.Send([]byte("late")), mux.ErrConnDone)
+
+	md, err := conn.Header()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", md["h"])
+	in, err := conn.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "reply", string(in))
+	_, err = conn.Recv(context.Background())
+	var se *mux.StatusError
+	assert.True(t, errors.As(err, &se))
+	// the end of the stream is sticky
+	_, err = conn.Recv(context.Background())
+	assert.True(t, errors.As(err, &se))
+	assert.NotErrorIs(t, err, io.EOF)
+
+	assert.NoError(t, conn.Reset(mux.CodeCancel, "bye"))
+	assert.Equal(t, mux.CodeCancel, conn.ResetErr().Code)
+}
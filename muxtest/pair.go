@@ -0,0 +1,38 @@
+package muxtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/orbit-w/mux-go"
+)
+
+/*
+   @Author: orbit-w
+   @File: pair
+   @2026 10月 周日 21:30
+*/
+
+// NewPair starts a server with handler on an in-memory Listener and returns a client
+// multiplexer connected to it, both are closed when the test ends.
+// A nil handler serves in accept mode, see mux.Server.Accept. conf may be nil.
+// NewPair 在内存监听器上启动服务并返回已连接的客户端多路复用器，测试结束时自动关闭，不占用网络端口
+func NewPair(tb testing.TB, handler func(conn mux.IServerConn) error, conf *mux.MuxServerConfig, cliConf ...mux.MuxClientConfig) (mux.IMux, *mux.Server) {
+	tb.Helper()
+	ln := NewListener()
+	s := new(mux.Server)
+	if err := s.ServeTransport(ln, handler, conf); err != nil {
+		tb.Fatalf("muxtest: serve: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = s.Stop()
+	})
+
+	conn, err := ln.Dial(context.Background())
+	if err != nil {
+		tb.Fatalf("muxtest: dial: %v", err)
+	}
+	multiplexer := mux.NewMultiplexer(context.Background(), conn, cliConf...)
+	tb.Cleanup(multiplexer.Close)
+	return multiplexer, s
+}
@@ -0,0 +1,72 @@
+package muxtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/mux-go"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: pair_test
+   @2026 10月 周日 22:10
+*/
+
+func echo(conn mux.IServerConn) error {
+	for {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return nil
+		}
+		if err = conn.Send(in); err != nil {
+			return err
+		}
+	}
+}
+
+func TestNewPair(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		t.Run(fmt.Sprintf("pair-%d", i), func(t *testing.T) {
+			t.Parallel()
+			multiplexer, _ := NewPair(t, echo, nil)
+
+			vc, err := multiplexer.NewVirtualConn(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "pipe", vc.RemoteAddr().(*mux.Addr).Addr.Network())
+			for j := 0; j < 10; j++ {
+				msg := fmt.Sprintf("msg-%d", j)
+				assert.NoError(t, vc.Send([]byte(msg)))
+				in, err := vc.Recv(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, msg, string(in))
+			}
+			assert.NoError(t, vc.CloseSend())
+			_, err = vc.Recv(context.Background())
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestNewPair_Accept(t *testing.T) {
+	multiplexer, s := NewPair(t, nil, mux.DevelopmentServerConfig())
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	sc, err := s.Accept(ctx)
+	assert.NoError(t, err)
+	in, err := sc.Recv(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+	sc.Close()
+	_, err = vc.Recv(ctx)
+	assert.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,161 @@
+package muxtest
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/orbit-w/mux-go"
+)
+
+/*
+   @Author: orbit-w
+   @File: pipe
+   @2026 10月 周日 21:10
+*/
+
+// pipeBuffer the packets a side can have in flight before Send blocks
+const pipeBuffer = 1024
+
+// Addr the address of the in-memory transport
+type Addr string
+
+func (a Addr) Network() string {
+	return "pipe"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// Pipe returns the two ends of an in-memory framed physical connection, each Send on one
+// end is received by one Recv on the other. Closing either end closes the connection,
+// the packets already sent are still received before io.EOF.
+// Pipe 返回内存中的物理连接两端，任意一端关闭后，对端读完已发送的数据后返回 io.EOF
+func Pipe() (mux.TransportConn, mux.TransportConn) {
+	a2b := make(chan []byte, pipeBuffer)
+	b2a := make(chan []byte, pipeBuffer)
+	p := &pipe{done: make(chan struct{})}
+	a := &pipeConn{pipe: p, in: b2a, out: a2b, local: "pipe-a", remote: "pipe-b"}
+	b := &pipeConn{pipe: p, in: a2b, out: b2a, local: "pipe-b", remote: "pipe-a"}
+	return a, b
+}
+
+type pipe struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (p *pipe) close() {
+	p.once.Do(func() {
+		close(p.done)
+	})
+}
+
+type pipeConn struct {
+	*pipe
+	in     chan []byte
+	out    chan []byte
+	local  Addr
+	remote Addr
+}
+
+func (c *pipeConn) Send(data []byte) error {
+	select {
+	case <-c.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	select {
+	case c.out <- buf:
+		return nil
+	case <-c.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (c *pipeConn) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case in := <-c.in:
+		return in, nil
+	default:
+	}
+	select {
+	case in := <-c.in:
+		return in, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		// drain the packets sent before the close
+		select {
+		case in := <-c.in:
+			return in, nil
+		default:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (c *pipeConn) Close() error {
+	c.close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Listener is an in-memory mux.TransportListener, Dial connects to it through a Pipe
+// Listener 内存中的监听器，通过 Dial 建立连接
+type Listener struct {
+	conns chan mux.TransportConn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func NewListener() *Listener {
+	return &Listener{
+		conns: make(chan mux.TransportConn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (ln *Listener) Accept() (mux.TransportConn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Dial opens a physical connection to the listener, it blocks until the connection is accepted
+func (ln *Listener) Dial(ctx context.Context) (mux.TransportConn, error) {
+	local, remote := Pipe()
+	select {
+	case ln.conns <- remote:
+		return local, nil
+	case <-ln.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (ln *Listener) Close() error {
+	ln.once.Do(func() {
+		close(ln.done)
+	})
+	return nil
+}
+
+func (ln *Listener) Addr() net.Addr {
+	return Addr("pipe")
+}
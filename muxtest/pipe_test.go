@@ -0,0 +1,66 @@
+package muxtest
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: pipe_test
+   @2026 10月 周日 22:00
+*/
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+	assert.NoError(t, a.Send([]byte("hello")))
+	assert.NoError(t, a.Send([]byte("world")))
+	assert.NoError(t, a.Close())
+
+	// the packets sent before the close are still received
+	in, err := b.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+	in, err = b.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(in))
+	_, err = b.Recv(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+	assert.ErrorIs(t, b.Send([]byte("late")), io.ErrClosedPipe)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	c, _ := Pipe()
+	_, err = c.Recv(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestListener(t *testing.T) {
+	ln := NewListener()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		in, _ := conn.Recv(context.Background())
+		_ = conn.Send(in)
+	}()
+
+	conn, err := ln.Dial(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Send([]byte("ping")))
+	in, err := conn.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(in))
+
+	assert.NoError(t, ln.Close())
+	_, err = ln.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+	_, err = ln.Dial(context.Background())
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,88 @@
+package muxtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+   @Author: orbit-w
+   @File: tls
+   @2026 10月 周日 23:10
+*/
+
+// TLSConfigs returns the TLS configs of a server and a client signed by a throwaway CA.
+// The server certificate is valid for localhost and 127.0.0.1 and requires a client
+// certificate (mutual TLS), the client certificate has the common name clientName.
+// TLSConfigs 生成临时CA签发的服务端与客户端TLS配置，服务端要求客户端证书（双向TLS）
+func TLSConfigs(tb testing.TB, clientName string) (server *tls.Config, client *tls.Config) {
+	tb.Helper()
+	caKey, caCert := newCert(tb, "muxtest ca", nil, nil, func(tpl *x509.Certificate) {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+	})
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	serverKey, serverCert := newCert(tb, "localhost", caKey, caCert, func(tpl *x509.Certificate) {
+		tpl.DNSNames = []string{"localhost"}
+		tpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	})
+	clientKey, clientCert := newCert(tb, clientName, caKey, caCert, func(tpl *x509.Certificate) {
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	})
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+		RootCAs:      pool,
+	}
+	return server, client
+}
+
+// newCert creates a certificate signed by parent, self-signed if parent is nil
+func newCert(tb testing.TB, name string, parentKey *ecdsa.PrivateKey, parent *x509.Certificate,
+	setup func(tpl *x509.Certificate)) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatalf("muxtest: generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		tb.Fatalf("muxtest: serial: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	setup(tpl)
+	if parent == nil {
+		parent, parentKey = tpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		tb.Fatalf("muxtest: create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatalf("muxtest: parse certificate: %v", err)
+	}
+	return key, cert
+}
@@ -0,0 +1,82 @@
+package muxtest
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/mux-go"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: tls_test
+   @2026 10月 周日 23:30
+*/
+
+// 双向TLS：处理函数根据客户端证书鉴权
+func TestServer_MutualTLS(t *testing.T) {
+	serverTLS, clientTLS := TLSConfigs(t, "order-service")
+	conf := mux.DefaultServerConfig()
+	conf.TLSConfig = serverTLS
+	s := new(mux.Server)
+	assert.NoError(t, s.ServeByConfig("127.0.0.1:0", func(conn mux.IServerConn) error {
+		st := conn.TLSConnectionState()
+		if st == nil || len(st.VerifiedChains) == 0 {
+			return mux.NewStatusError(mux.CodeRefusedStream, "no client certificate")
+		}
+		return conn.Send([]byte(st.VerifiedChains[0][0].Subject.CommonName))
+	}, conf))
+	defer s.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := mux.DialTLS(ctx, s.Addr(), clientTLS)
+	assert.NoError(t, err)
+	multiplexer := mux.NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(ctx)
+	assert.NoError(t, err)
+	in, err := vc.Recv(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "order-service", string(in))
+}
+
+func TestServer_MutualTLSNoClientCert(t *testing.T) {
+	serverTLS, clientTLS := TLSConfigs(t, "anonymous")
+	conf := mux.DefaultServerConfig()
+	conf.TLSConfig = serverTLS
+	s := new(mux.Server)
+	assert.NoError(t, s.ServeByConfig("127.0.0.1:0", func(conn mux.IServerConn) error {
+		return conn.Send([]byte("hello"))
+	}, conf))
+	defer s.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := mux.DialTLS(ctx, s.Addr(), &tls.Config{RootCAs: clientTLS.RootCAs})
+	if err != nil {
+		// TLS 1.2 fails the handshake at once
+		return
+	}
+	multiplexer := mux.NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	// TLS 1.3 reports the rejected certificate on the first read
+	select {
+	case <-multiplexer.Done():
+		assert.Error(t, multiplexer.Err())
+	case <-ctx.Done():
+		t.Fatal("connection without client certificate not rejected")
+	}
+}
+
+func TestServerConn_TLS(t *testing.T) {
+	conn := NewServerConn(context.Background())
+	assert.Nil(t, conn.TLSConnectionState())
+	conn.TLS = &tls.ConnectionState{ServerName: "fake"}
+	assert.Equal(t, "fake", conn.TLSConnectionState().ServerName)
+}
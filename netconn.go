@@ -0,0 +1,144 @@
+package mux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+   @Author: orbit-w
+   @File: netconn
+   @2026 10月 周日 14:10
+*/
+
+// NetStream is the part of a virtual conn NewNetConn needs, both IConn and IServerConn implement it
+type NetStream interface {
+	Send(data []byte) error
+	Recv(ctx context.Context) ([]byte, error)
+	CloseSend() error
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Addr is the address of a virtual conn, the address of the physical connection plus the stream id
+// Addr 虚拟连接的地址：物理连接地址 + 虚拟连接ID
+type Addr struct {
+	Addr     net.Addr //address of the physical connection, nil if the transport does not expose it
+	StreamId int64
+}
+
+func (a *Addr) Network() string {
+	return "mux"
+}
+
+func (a *Addr) String() string {
+	if a.Addr == nil {
+		return fmt.Sprintf("unknown#%d", a.StreamId)
+	}
+	return fmt.Sprintf("%s#%d", a.Addr.String(), a.StreamId)
+}
+
+func (vc *VirtualConn) LocalAddr() net.Addr {
+	var addr net.Addr
+	if c, ok := vc.conn.(interface{ LocalAddr() net.Addr }); ok {
+		addr = c.LocalAddr()
+	}
+	return &Addr{Addr: addr, StreamId: vc.Id()}
+}
+
+func (vc *VirtualConn) RemoteAddr() net.Addr {
+	var addr net.Addr
+	if c, ok := vc.conn.(interface{ RemoteAddr() net.Addr }); ok {
+		addr = c.RemoteAddr()
+	}
+	return &Addr{Addr: addr, StreamId: vc.Id()}
+}
+
+// NewNetConn wraps a virtual conn into a net.Conn with byte-stream semantics:
+// Read hands out the received messages in pieces as small as the caller's buffer,
+// Write sends each call as one message. Close finishes the virtual conn.
+// NewNetConn 将虚拟连接包装为字节流语义的 net.Conn
+func NewNetConn(s NetStream) net.Conn {
+	return &netConn{stream: s}
+}
+
+type netConn struct {
+	stream NetStream
+	rmu    sync.Mutex
+	buf    []byte //the part of the last message not read yet
+	closed atomic.Bool
+}
+
+func (c *netConn) Read(b []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.buf) == 0 {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		in, err := c.stream.Recv(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		c.buf = in
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *netConn) Write(b []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := c.stream.Send(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close finishes the virtual conn with a Fin frame, streams that cannot be finished
+// from this side only close their send direction
+func (c *netConn) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return net.ErrClosed
+	}
+	if f, ok := c.stream.(interface{ Close() }); ok {
+		f.Close()
+		return nil
+	}
+	return c.stream.CloseSend()
+}
+
+func (c *netConn) LocalAddr() net.Addr {
+	return c.stream.LocalAddr()
+}
+
+func (c *netConn) RemoteAddr() net.Addr {
+	return c.stream.RemoteAddr()
+}
+
+func (c *netConn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *netConn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *netConn) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
@@ -0,0 +1,69 @@
+package mux
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: netconn_test
+   @2026 10月 周日 14:40
+*/
+
+func TestNetConn(t *testing.T) {
+	closed := make(chan error, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		// a line based protocol served through bufio
+		nc := NewNetConn(conn)
+		r := bufio.NewReader(nc)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				closed <- err
+				return nil
+			}
+			if _, err = fmt.Fprintf(nc, "%s:%s", nc.RemoteAddr().Network(), strings.ToUpper(line)); err != nil {
+				return err
+			}
+		}
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	var nc net.Conn = NewNetConn(vc)
+	assert.True(t, strings.HasSuffix(nc.LocalAddr().String(), fmt.Sprintf("#%d", vc.(*VirtualConn).Id())))
+
+	_, err = nc.Write([]byte("hello\nworld\n"))
+	assert.NoError(t, err)
+
+	// partial reads keep the rest of the message
+	want := "mux:HELLO\nmux:WORLD\n"
+	buf := make([]byte, 3)
+	var out []byte
+	for len(out) < len(want) {
+		n, err := nc.Read(buf)
+		assert.NoError(t, err)
+		out = append(out, buf[:n]...)
+	}
+	assert.Equal(t, want, string(out))
+
+	assert.NoError(t, nc.Close())
+	assert.ErrorIs(t, nc.Close(), net.ErrClosed)
+	_, err = nc.Write([]byte("late"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Equal(t, io.EOF, <-closed)
+}
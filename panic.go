@@ -0,0 +1,60 @@
+package mux
+
+import (
+	"log"
+	"runtime/debug"
+
+	"github.com/orbit-w/mux-go/metadata"
+)
+
+/*
+   @Author: orbit-w
+   @File: panic
+   @2026 10月 周日 19:20
+*/
+
+// PanicInfo describes a panic recovered from the handler of a virtual conn
+// PanicInfo 处理函数panic时的现场信息
+type PanicInfo struct {
+	Value    any         //the value passed to panic
+	Stack    []byte      //the stack of the handler goroutine
+	StreamId int64       //the id of the virtual conn
+	MD       metadata.MD //the incoming metadata of the virtual conn
+}
+
+// crashOnPanic reports whether handler panics are left unrecovered
+func (mux *Multiplexer) crashOnPanic() bool {
+	return !mux.isClient && mux.server.conf.CrashOnPanic
+}
+
+// panicHandler returns the PanicHandler configured on this side, nil if none
+func (mux *Multiplexer) panicHandler() func(info *PanicInfo) {
+	if mux.isClient {
+		return mux.conf.PanicHandler
+	}
+	return mux.server.conf.PanicHandler
+}
+
+// recoverHandler recovers a panicking handler, reports it to the PanicHandler, or logs the
+// panic value, the stack and the stream id when none is set, and resets the virtual conn
+// with CodeInternalError so the peer does not take it for a normal end.
+func (mux *Multiplexer) recoverHandler(vc *VirtualConn) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	md, _ := metadata.FromIncomingContext(vc.Context())
+	info := &PanicInfo{
+		Value:    r,
+		Stack:    debug.Stack(),
+		StreamId: vc.Id(),
+		MD:       md,
+	}
+	if handler := mux.panicHandler(); handler != nil {
+		handler(info)
+	} else {
+		log.Printf("mux: handler of virtual conn %d panic: %v\n%s", info.StreamId, r, info.Stack)
+	}
+	_ = vc.Reset(CodeInternalError, "handler panic")
+}
@@ -0,0 +1,141 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/orbit-w/mux-go/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: panic_test
+   @2026 10月 周日 19:40
+*/
+
+// 处理函数panic时调用 PanicHandler，客户端收到 CodeInternalError 的RST
+func TestServer_PanicHandler(t *testing.T) {
+	panics := make(chan *PanicInfo, 1)
+	conf := DefaultServerConfig()
+	conf.PanicHandler = func(info *PanicInfo) {
+		panics <- info
+	}
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		panic("boom")
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	ctx := metadata.NewOutContext(context.Background(), metadata.MD{"trace": "t-1"})
+	vc, err := multiplexer.NewVirtualConn(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+
+	_, err = vc.Recv(context.Background())
+	var re *ResetError
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, CodeInternalError, re.Code)
+	assert.True(t, re.Remote)
+
+	select {
+	case info := <-panics:
+		assert.Equal(t, "boom", info.Value)
+		assert.Equal(t, vc.(*VirtualConn).Id(), info.StreamId)
+		assert.Equal(t, "t-1", info.MD["trace"])
+		assert.Contains(t, string(info.Stack), "panic_test.go")
+	case <-time.After(time.Second * 5):
+		t.Fatal("panic handler not called")
+	}
+
+	// the physical connection is still usable
+	_, err = multiplexer.Ping(context.Background())
+	assert.NoError(t, err)
+}
+
+// 未设置 PanicHandler 时输出panic值、堆栈与虚拟连接ID，虚拟连接仍以 CodeInternalError 重置
+func TestServer_PanicWithoutHandler(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		panic("boom")
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+	_, err = vc.Recv(context.Background())
+	var re *ResetError
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, CodeInternalError, re.Code)
+	assert.Contains(t, out.String(), "boom")
+	assert.Contains(t, out.String(), fmt.Sprintf("virtual conn %d", vc.(*VirtualConn).Id()))
+	assert.Contains(t, out.String(), "panic_test.go")
+}
+
+// 客户端处理服务端推送时panic，调用客户端的 PanicHandler
+func TestClient_PanicHandler(t *testing.T) {
+	reset := make(chan error, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		push, err := conn.Mux().NewVirtualConn(context.Background())
+		if err != nil {
+			reset <- err
+			return nil
+		}
+		_, err = push.Recv(context.Background())
+		reset <- err
+		return nil
+	})
+	defer s.Stop()
+
+	panics := make(chan *PanicInfo, 1)
+	conf := DefaultClientConfig()
+	conf.AcceptHandler = func(conn IServerConn) error {
+		panic("boom")
+	}
+	conf.PanicHandler = func(info *PanicInfo) {
+		panics <- info
+	}
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, conf)
+	defer multiplexer.Close()
+
+	_, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	select {
+	case info := <-panics:
+		assert.Equal(t, "boom", info.Value)
+		assert.Contains(t, string(info.Stack), "panic_test.go")
+	case <-time.After(time.Second * 5):
+		t.Fatal("panic handler not called")
+	}
+	select {
+	case err = <-reset:
+		var re *ResetError
+		assert.True(t, errors.As(err, &re))
+		assert.Equal(t, CodeInternalError, re.Code)
+	case <-time.After(time.Second * 5):
+		t.Fatal("push stream not reset")
+	}
+}
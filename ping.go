@@ -0,0 +1,171 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+   @Author: orbit-w
+   @File: ping
+   @2026 10月 周六 15:40
+*/
+
+const pingDataLength = 8
+
+// pinger matches the PONG frames with the pending pings and keeps
+// the smoothed round-trip time of the physical connection.
+// pinger 负责匹配PING/PONG，并维护物理连接的平滑RTT
+type pinger struct {
+	mu      sync.Mutex
+	seq     uint64
+	waiters map[uint64]chan struct{}
+	srtt    time.Duration
+}
+
+func newPinger() *pinger {
+	return &pinger{
+		waiters: make(map[uint64]chan struct{}),
+	}
+}
+
+func (p *pinger) register() (uint64, chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	ch := make(chan struct{})
+	p.waiters[p.seq] = ch
+	return p.seq, ch
+}
+
+func (p *pinger) unregister(id uint64) {
+	p.mu.Lock()
+	delete(p.waiters, id)
+	p.mu.Unlock()
+}
+
+func (p *pinger) ack(id uint64) {
+	p.mu.Lock()
+	ch, ok := p.waiters[id]
+	delete(p.waiters, id)
+	p.mu.Unlock()
+	if ok {
+		close(ch)
+	}
+}
+
+// update smooths the samples the same way as TCP: srtt = 7/8 * srtt + 1/8 * rtt
+func (p *pinger) update(rtt time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.srtt == 0 {
+		p.srtt = rtt
+		return
+	}
+	p.srtt = p.srtt - p.srtt/8 + rtt/8
+}
+
+func (p *pinger) rtt() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.srtt
+}
+
+// Ping sends a PING frame and waits for the PONG, it returns the measured round-trip time
+// Ping 发送PING帧并等待对端的PONG，返回本次测得的往返时间
+func (mux *Multiplexer) Ping(ctx context.Context) (time.Duration, error) {
+	if mux.state.Load() != StateMuxRunning {
+		return 0, ErrMuxClosed
+	}
+
+	id, ch := mux.pinger.register()
+	defer mux.pinger.unregister(id)
+
+	start := time.Now()
+	if err := mux.sendMsg(&Msg{
+		Type: MessagePing,
+		Data: encodePing(id),
+	}); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-ch:
+		rtt := time.Since(start)
+		mux.pinger.update(rtt)
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-mux.ctx.Done():
+		return 0, ErrMuxClosed
+	}
+}
+
+// RTT returns the smoothed round-trip time, 0 before the first PONG arrives
+// RTT 返回平滑后的往返时间，收到第一个PONG之前为0
+func (mux *Multiplexer) RTT() time.Duration {
+	return mux.pinger.rtt()
+}
+
+// keepaliveLoop pings the peer every interval, so an idle physical connection stays alive
+// and a half-dead one is detected early. A missed PONG tears down the multiplexer with ErrKeepaliveTimeout.
+// Legacy peers are not pinged.
+// 定时PING对端以保活空闲的物理连接，并尽早发现半死连接；超时未收到PONG则以 ErrKeepaliveTimeout 关闭多路复用器；旧版本对端不做保活
+func (mux *Multiplexer) keepaliveLoop(interval, timeout time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mux.ctx.Done():
+			return
+		case <-ticker.C:
+			if !mux.hs.negotiated() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(mux.ctx, timeout)
+			_, err := mux.Ping(ctx)
+			cancel()
+			if err != nil {
+				if mux.ctx.Err() == nil {
+					mux.closeWith(ErrKeepaliveTimeout)
+				}
+				return
+			}
+		}
+	}
+}
+
+func handlePing(mux *Multiplexer, in *Msg) {
+	_ = mux.sendMsg(&Msg{
+		Type: MessagePong,
+		Data: in.Data,
+	})
+}
+
+func handlePong(mux *Multiplexer, in *Msg) {
+	id, err := decodePing(in.Data)
+	if err != nil {
+		return
+	}
+	mux.pinger.ack(id)
+}
+
+func encodePing(id uint64) []byte {
+	buf := make([]byte, pingDataLength)
+	binary.BigEndian.PutUint64(buf, id)
+	return buf
+}
+
+func decodePing(data []byte) (uint64, error) {
+	if len(data) < pingDataLength {
+		return 0, errors.New("invalid ping frame")
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
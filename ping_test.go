@@ -0,0 +1,88 @@
+package mux
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: ping_test
+   @2026 10月 周六 16:30
+*/
+
+func TestMultiplexer_Ping(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+
+	assert.Equal(t, time.Duration(0), multiplexer.RTT())
+	for i := 0; i < 10; i++ {
+		rtt, err := multiplexer.Ping(context.Background())
+		assert.NoError(t, err)
+		assert.Greater(t, rtt, time.Duration(0))
+	}
+	assert.Greater(t, multiplexer.RTT(), time.Duration(0))
+
+	multiplexer.Close()
+	_, err := multiplexer.Ping(context.Background())
+	assert.ErrorIs(t, err, ErrMuxClosed)
+}
+
+// 对端不响应PING时，保活超时会关闭多路复用器
+func TestMultiplexer_KeepaliveTimeout(t *testing.T) {
+	// a raw peer that completes the handshake but never answers
+	ts, err := transport.ServeByConfig("tcp", "localhost:0", func(conn transport.IConn) {
+		settings := Settings{Version: ProtocolVersion}
+		_ = conn.Send(new(Codec).Encode(&Msg{Type: MessageSettings, Data: settings.encode()}).Data())
+		for {
+			if _, err := conn.Recv(context.Background()); err != nil {
+				return
+			}
+		}
+	}, DefaultServerConfig().toTransportConfig())
+	assert.NoError(t, err)
+	defer ts.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), ts.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, MuxClientConfig{
+		KeepaliveInterval: time.Millisecond * 50,
+		KeepaliveTimeout:  time.Millisecond * 50,
+	})
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	_, err = vc.Recv(context.Background())
+	assert.ErrorIs(t, err, ErrKeepaliveTimeout)
+}
+
+// 未发送SETTINGS的旧版本对端不响应PING，不做保活
+func TestMultiplexer_KeepaliveLegacyPeer(t *testing.T) {
+	ts, err := transport.ServeByConfig("tcp", "localhost:0", func(conn transport.IConn) {
+		for {
+			if _, err := conn.Recv(context.Background()); err != nil {
+				return
+			}
+		}
+	}, DefaultServerConfig().toTransportConfig())
+	assert.NoError(t, err)
+	defer ts.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), ts.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, MuxClientConfig{
+		KeepaliveInterval: time.Millisecond * 20,
+		KeepaliveTimeout:  time.Millisecond * 20,
+	})
+	defer multiplexer.Close()
+
+	time.Sleep(time.Millisecond * 200)
+	assert.NoError(t, multiplexer.Err())
+}
@@ -0,0 +1,260 @@
+package mux
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/orbit-w/meteor/modules/net/packet"
+)
+
+/*
+   @Author: orbit-w
+   @File: scheduler
+   @2026 10月 周六 20:10
+*/
+
+// DefaultWeight is the scheduling weight of a virtual conn unless set by WithWeight or SetWeight
+// DefaultWeight 虚拟连接默认的调度权重
+const DefaultWeight uint8 = 16
+
+type weightKey struct{}
+
+// WithWeight sets the scheduling weight of the virtual conn opened by NewVirtualConn with ctx.
+// Streams with pending data share the physical connection in proportion to their weights,
+// a weight of 0 is treated as 1.
+// WithWeight 设置 NewVirtualConn 创建的虚拟连接的调度权重，有待发送数据的虚拟连接按权重比例分享物理连接
+func WithWeight(ctx context.Context, weight uint8) context.Context {
+	return context.WithValue(ctx, weightKey{}, weight)
+}
+
+func weightFromContext(ctx context.Context) uint8 {
+	if w, ok := ctx.Value(weightKey{}).(uint8); ok {
+		return w
+	}
+	return DefaultWeight
+}
+
+// scheduler orders the frames of a multiplexer before they are written by writeLoop.
+// Control frames are always written first, the data frames of the virtual conns are
+// queued per stream and drained by weighted fair queuing: every stream carries a virtual
+// finish time advanced by size/weight for each frame, the stream with the smallest one goes next.
+// scheduler 控制帧优先发送，数据帧按虚拟连接排队，按加权公平队列调度
+type scheduler struct {
+	mu      sync.Mutex
+	notify  chan struct{}
+	control []packet.IPacket
+	streams map[int64]*streamQueue
+	active  streamHeap
+	vtime   uint64
+	closed  bool
+}
+
+type streamQueue struct {
+	id     int64
+	weight uint8
+	pass   uint64 //virtual finish time of the last frame taken from the queue
+	index  int
+	frames []packet.IPacket
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		notify:  make(chan struct{}, 1),
+		streams: make(map[int64]*streamQueue),
+	}
+}
+
+// pushControl queues a frame that bypasses the data of all virtual conns
+func (s *scheduler) pushControl(p packet.IPacket) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		packet.Return(p)
+		return ErrMuxClosed
+	}
+	s.control = append(s.control, p)
+	s.mu.Unlock()
+	s.wakeUp()
+	return nil
+}
+
+// push queues a data frame of the virtual conn id
+func (s *scheduler) push(id int64, weight uint8, p packet.IPacket) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		packet.Return(p)
+		return ErrMuxClosed
+	}
+	q, ok := s.streams[id]
+	if !ok {
+		// an idle stream starts from the current virtual time, it gets no credit for being idle
+		q = &streamQueue{id: id, pass: s.vtime}
+		s.streams[id] = q
+		heap.Push(&s.active, q)
+	}
+	q.weight = weight
+	q.frames = append(q.frames, p)
+	s.mu.Unlock()
+	s.wakeUp()
+	return nil
+}
+
+// pushInOrder queues a non-data frame of the virtual conn id (end of stream, header), it must
+// not overtake the data still queued for the stream but bypasses everything else
+func (s *scheduler) pushInOrder(id int64, p packet.IPacket) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		packet.Return(p)
+		return ErrMuxClosed
+	}
+	if q, ok := s.streams[id]; ok {
+		q.frames = append(q.frames, p)
+	} else {
+		s.control = append(s.control, p)
+	}
+	s.mu.Unlock()
+	s.wakeUp()
+	return nil
+}
+
+// drop discards the frames queued for the virtual conn id
+func (s *scheduler) drop(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.streams[id]
+	if !ok {
+		return
+	}
+	heap.Remove(&s.active, q.index)
+	delete(s.streams, id)
+	for i := range q.frames {
+		packet.Return(q.frames[i])
+	}
+}
+
+// next blocks until there is a frame to write, ok is false once the scheduler
+// is closed and all the queued frames have been taken
+func (s *scheduler) next() (p packet.IPacket, ok bool) {
+	for {
+		s.mu.Lock()
+		if p = s.pop(); p != nil {
+			s.mu.Unlock()
+			return p, true
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-s.notify
+	}
+}
+
+func (s *scheduler) pop() packet.IPacket {
+	if len(s.control) > 0 {
+		p := s.control[0]
+		s.control[0] = nil
+		s.control = s.control[1:]
+		return p
+	}
+	if len(s.active) == 0 {
+		return nil
+	}
+
+	q := s.active[0]
+	p := q.frames[0]
+	q.frames[0] = nil
+	q.frames = q.frames[1:]
+	s.vtime = q.pass
+	q.pass += cost(len(p.Data()), q.weight)
+	if len(q.frames) == 0 {
+		heap.Pop(&s.active)
+		delete(s.streams, q.id)
+	} else {
+		heap.Fix(&s.active, q.index)
+	}
+	return p
+}
+
+// close stops accepting frames, the ones already queued are still handed out by next
+func (s *scheduler) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.wakeUp()
+}
+
+func (s *scheduler) wakeUp() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func cost(size int, weight uint8) uint64 {
+	if weight == 0 {
+		weight = 1
+	}
+	return uint64(size+1) * 256 / uint64(weight)
+}
+
+type streamHeap []*streamQueue
+
+func (h streamHeap) Len() int { return len(h) }
+
+func (h streamHeap) Less(i, j int) bool {
+	if h[i].pass == h[j].pass {
+		return h[i].id < h[j].id
+	}
+	return h[i].pass < h[j].pass
+}
+
+func (h streamHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *streamHeap) Push(x any) {
+	q := x.(*streamQueue)
+	q.index = len(*h)
+	*h = append(*h, q)
+}
+
+func (h *streamHeap) Pop() any {
+	old := *h
+	n := len(old)
+	q := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return q
+}
+
+// writeLoop writes the frames handed out by the scheduler to the physical connection,
+// the connection is closed once the multiplexer is closed and the queued frames are flushed
+func (mux *Multiplexer) writeLoop() {
+	var err error
+	defer func() {
+		if mux.conn != nil {
+			_ = mux.conn.Close()
+		}
+	}()
+
+	for {
+		p, ok := mux.sched.next()
+		if !ok {
+			return
+		}
+		if err == nil {
+			if err = mux.conn.Send(p.Data()); err != nil {
+				mux.closeWith(err)
+			} else {
+				mux.hs.start()
+			}
+		}
+		packet.Return(p)
+	}
+}
@@ -0,0 +1,84 @@
+package mux
+
+import (
+	"context"
+	"testing"
+
+	"github.com/orbit-w/meteor/modules/net/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: scheduler_test
+   @2026 10月 周六 20:45
+*/
+
+func frameOf(id int64, tag byte, size int) packet.IPacket {
+	data := make([]byte, size)
+	data[0] = tag
+	return new(Codec).Encode(&Msg{Type: MessageRaw, Id: id, Data: data})
+}
+
+func popId(t *testing.T, s *scheduler) (int64, byte) {
+	p, ok := s.next()
+	assert.True(t, ok)
+	msg, err := new(Codec).DecodeV2(p.Data())
+	assert.NoError(t, err)
+	if len(msg.Data) == 0 {
+		return msg.Id, 0
+	}
+	return msg.Id, msg.Data[0]
+}
+
+func TestScheduler_Weight(t *testing.T) {
+	s := newScheduler()
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, s.push(1, 1, frameOf(1, 'd', 1024)))
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, s.push(3, 4, frameOf(3, 'd', 1024)))
+	}
+	assert.NoError(t, s.pushControl(frameOf(0, 'c', 1)))
+
+	id, tag := popId(t, s)
+	assert.Equal(t, int64(0), id)
+	assert.Equal(t, byte('c'), tag)
+
+	// the stream of weight 4 drains four times faster
+	var light int
+	for i := 0; i < 5; i++ {
+		if id, _ = popId(t, s); id == 1 {
+			light++
+		}
+	}
+	assert.Equal(t, 1, light)
+}
+
+func TestScheduler_End(t *testing.T) {
+	s := newScheduler()
+	assert.NoError(t, s.push(1, DefaultWeight, frameOf(1, 'd', 16)))
+	assert.NoError(t, s.pushInOrder(1, frameOf(1, 'e', 1)))
+	// the end of an idle stream goes with the control frames
+	assert.NoError(t, s.pushInOrder(3, frameOf(3, 'e', 1)))
+
+	id, tag := popId(t, s)
+	assert.Equal(t, int64(3), id)
+	assert.Equal(t, byte('e'), tag)
+	_, tag = popId(t, s)
+	assert.Equal(t, byte('d'), tag)
+	_, tag = popId(t, s)
+	assert.Equal(t, byte('e'), tag)
+
+	assert.NoError(t, s.push(5, DefaultWeight, frameOf(5, 'd', 16)))
+	s.drop(5)
+	s.close()
+	_, ok := s.next()
+	assert.False(t, ok)
+	assert.Equal(t, ErrMuxClosed, s.pushControl(frameOf(0, 'c', 1)))
+}
+
+func TestWithWeight(t *testing.T) {
+	assert.Equal(t, DefaultWeight, weightFromContext(context.Background()))
+	assert.Equal(t, uint8(200), weightFromContext(WithWeight(context.Background(), 200)))
+}
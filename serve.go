@@ -2,6 +2,8 @@ package mux
 
 import (
 	"context"
+	"crypto/tls"
+	"sync"
 	"time"
 
 	"github.com/orbit-w/meteor/modules/net/network"
@@ -15,10 +17,19 @@ import (
 */
 
 type Server struct {
+	conf       *MuxServerConfig
 	server     transport.IServer
 	ctx        context.Context
 	cancel     context.CancelFunc
 	handleLoop func(conn IServerConn) error
+	acceptCh   chan IServerConn //virtual conns waiting for Accept, nil if a handler is set
+
+	mu           sync.Mutex
+	muxes        map[*Multiplexer]struct{}
+	handlers     int           //handlers running or about to run
+	shuttingDown bool          //Shutdown was called, new physical connections are refused
+	drained      chan struct{} //closed once shutting down with no handler and no physical connection left
+	stopErr      error         //the error the transport stopped with, reported by Wait
 }
 
 // Serve 以默认配置启动服务
@@ -30,17 +41,16 @@ func (s *Server) Serve(addr string, handleLoop func(conn IServerConn) error) err
 
 // ServeByConfig 以指定配置启动服务
 // 业务侧只需要break/return即可，不需要调用 IServerConn.Close()，系统会自动关闭虚拟链接
+// handleLoop 为nil时服务以Accept模式运行，通过 Server.Accept / Server.Listener 获取虚拟连接
 func (s *Server) ServeByConfig(addr string, handleLoop func(conn IServerConn) error, conf *MuxServerConfig) error {
-	s.handleLoop = handleLoop
-	ctx, cancel := context.WithCancel(context.Background())
-	s.ctx = ctx
-	s.cancel = cancel
-	buildServerConfig(&conf)
+	if conf != nil && conf.TLSConfig != nil {
+		return s.serveTLS(addr, handleLoop, conf)
+	}
+	s.init(handleLoop, conf)
 
-	tConf := conf.toTransportConfig()
+	tConf := s.conf.toTransportConfig()
 	ts, err := transport.ServeByConfig("tcp", addr, func(conn transport.IConn) {
-		mux := newMultiplexer(s.ctx, conn, false, s)
-		mux.recvLoop()
+		s.serveConn(conn)
 	}, tConf)
 	if err != nil {
 		return err
@@ -49,11 +59,95 @@ func (s *Server) ServeByConfig(addr string, handleLoop func(conn IServerConn) er
 	return nil
 }
 
+func (s *Server) init(handleLoop func(conn IServerConn) error, conf *MuxServerConfig) {
+	s.handleLoop = handleLoop
+	if handleLoop == nil {
+		s.acceptCh = make(chan IServerConn)
+		s.handleLoop = s.acceptLoop
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx = ctx
+	s.cancel = cancel
+	buildServerConfig(&conf)
+	s.conf = conf
+
+	s.mu.Lock()
+	s.shuttingDown = false
+	s.drained = nil
+	s.stopErr = nil
+	s.mu.Unlock()
+}
+
+// serveConn runs the multiplexer of a physical connection until it is closed
+func (s *Server) serveConn(conn TransportConn) {
+	mux := newMultiplexer(s.ctx, conn, false, s)
+	if !s.addMux(mux) {
+		// shutting down, refuse the physical connection
+		mux.cancel()
+		_ = conn.Close()
+		return
+	}
+	defer s.delMux(mux)
+	_ = mux.sendSettings()
+	go mux.writeLoop()
+	go mux.keepaliveLoop(s.conf.KeepaliveInterval, s.conf.KeepaliveTimeout)
+	mux.authDeadline(s.conf.AuthTimeout)
+	mux.recvLoop()
+}
+
+// GoAway sends GOAWAY on every physical connection, the virtual conns in flight are allowed
+// to finish and each physical connection is closed once it is drained.
+// The clients are expected to reconnect for new virtual conns.
+// GoAway 向所有物理连接发送GOAWAY，已有虚拟连接可以正常结束，物理连接在排空后关闭，客户端需重新建立物理连接
+func (s *Server) GoAway() {
+	s.mu.Lock()
+	muxes := make([]*Multiplexer, 0, len(s.muxes))
+	for mux := range s.muxes {
+		muxes = append(muxes, mux)
+	}
+	s.mu.Unlock()
+
+	for i := range muxes {
+		_ = muxes[i].GoAway()
+	}
+}
+
+func (s *Server) addMux(mux *Multiplexer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	if s.muxes == nil {
+		s.muxes = make(map[*Multiplexer]struct{})
+	}
+	s.muxes[mux] = struct{}{}
+	return true
+}
+
+func (s *Server) delMux(mux *Multiplexer) {
+	s.mu.Lock()
+	delete(s.muxes, mux)
+	s.checkDrained()
+	s.mu.Unlock()
+}
+
+// Stop closes the listener and all physical connections at once, see Shutdown for a graceful stop
+// Stop 立即关闭监听与所有物理连接，优雅关闭请使用 Shutdown
 func (s *Server) Stop() error {
+	var err error
 	if s.server != nil {
-		return s.server.Stop()
+		err = s.server.Stop()
 	}
-	return nil
+	if s.cancel != nil {
+		s.mu.Lock()
+		if s.stopErr == nil {
+			s.stopErr = err
+		}
+		s.mu.Unlock()
+		s.cancel()
+	}
+	return err
 }
 
 func (s *Server) Addr() string {
@@ -69,10 +163,67 @@ func (s *Server) SetHandler(handle func(conn IServerConn) error) {
 
 type MuxServerConfig struct {
 	MaxIncomingPacket uint32
-	IsGzip            bool
-	ReadTimeout       time.Duration
-	WriteTimeout      time.Duration
+	IsGzip            bool          //meteor传输层压缩，TLS与 ServeListener 的分帧传输不支持
+	ReadTimeout       time.Duration //单次读取的超时时间
+	WriteTimeout      time.Duration //单次写入的超时时间
 	DialTimeout       time.Duration
+	KeepaliveInterval time.Duration //保活PING间隔，0 使用默认值，负数关闭保活
+	KeepaliveTimeout  time.Duration //等待PONG的超时时间
+
+	// MaxConcurrentStreams 单个物理连接的最大并发虚拟连接数，握手时通告给客户端，0 使用默认值；
+	// 每个虚拟连接最多缓存 InitialWindowSize 加一条消息的未读数据
+	MaxConcurrentStreams uint32
+	// InitialWindowSize 虚拟连接的初始接收窗口，握手时通告给客户端
+	InitialWindowSize uint32
+	// InitialConnWindowSize 物理连接的初始接收窗口，握手时通告给客户端
+	InitialConnWindowSize uint32
+	// MaxMessageSize 单个虚拟连接可重组的最大消息，握手时通告给客户端
+	MaxMessageSize uint32
+
+	// AcceptHook is called with the context of each new virtual conn before it is handled,
+	// the incoming metadata is available through metadata.FromIncomingContext.
+	// Returning an error rejects the virtual conn, a *StatusError gives the code and reason
+	// reported to the client, other errors are reported as CodeRefusedStream.
+	// AcceptHook 虚拟连接被处理前调用，返回错误则拒绝该虚拟连接
+	AcceptHook func(ctx context.Context) error
+
+	// PanicHandler is called when a handler panics, the virtual conn is then reset with
+	// CodeInternalError. The panic is logged when it is nil.
+	// PanicHandler 处理函数panic时调用，随后虚拟连接以 CodeInternalError 重置；为nil时输出panic值、堆栈与虚拟连接ID
+	PanicHandler func(info *PanicInfo)
+	// CrashOnPanic 处理函数panic时不做恢复，进程以原始堆栈崩溃，适用于快速失败的环境
+	CrashOnPanic bool
+
+	// TLSConfig enables TLS, the server then runs on the framed transport and the clients
+	// dial with DialTLS. Set ClientAuth to require and verify client certificates (mutual TLS),
+	// the handlers read the peer certificates from IServerConn.TLSConnectionState.
+	// TLSConfig 开启TLS，客户端需通过 DialTLS 连接；设置 ClientAuth 可开启双向TLS认证
+	TLSConfig *tls.Config
+
+	// Authenticate checks the credentials a client sends once per physical connection, before
+	// any virtual conn is accepted. The returned identity is available from the Context of every
+	// virtual conn of the connection through IdentityFromContext, an error rejects the whole
+	// physical connection. It runs on the receiving goroutine of the connection and must not block long.
+	// Authenticate 校验客户端在物理连接建立时发送的凭据，返回的身份可在该连接所有虚拟连接的
+	// Context 中通过 IdentityFromContext 获取；返回错误则拒绝整个物理连接
+	Authenticate func(ctx context.Context, creds []byte) (identity any, err error)
+	// AuthTimeout 等待客户端凭据通过认证的最长时间，超时则拒绝整个物理连接，0 使用默认值
+	AuthTimeout time.Duration
+
+	// MinCompressSize 选择了压缩算法的虚拟连接，短于该长度的消息不压缩，0 使用默认值
+	MinCompressSize int
+}
+
+func (conf *MuxServerConfig) toSettings() Settings {
+	return Settings{
+		Version:               ProtocolVersion,
+		MaxConcurrentStreams:  conf.MaxConcurrentStreams,
+		MaxFrameSize:          conf.MaxIncomingPacket,
+		InitialWindowSize:     conf.InitialWindowSize,
+		InitialConnWindowSize: conf.InitialConnWindowSize,
+		MaxMessageSize:        conf.MaxMessageSize,
+		Features:              FeatureCompression,
+	}
 }
 
 func (conf *MuxServerConfig) toTransportConfig() *transport.Config {
@@ -100,34 +251,90 @@ func buildServerConfig(conf **MuxServerConfig) {
 	if (*conf).MaxIncomingPacket == 0 {
 		(*conf).MaxIncomingPacket = network.MaxIncomingPacket
 	}
+
+	if (*conf).KeepaliveInterval == 0 {
+		(*conf).KeepaliveInterval = KeepaliveInterval
+	}
+
+	if (*conf).KeepaliveTimeout <= 0 {
+		(*conf).KeepaliveTimeout = KeepaliveTimeout
+	}
+
+	if (*conf).InitialWindowSize == 0 {
+		(*conf).InitialWindowSize = InitialWindowSize
+	}
+
+	if (*conf).MaxConcurrentStreams == 0 {
+		(*conf).MaxConcurrentStreams = MaxConcurrentStreams
+	}
+
+	if (*conf).InitialConnWindowSize == 0 {
+		(*conf).InitialConnWindowSize = InitialConnWindowSize
+	}
+
+	if (*conf).MaxMessageSize == 0 {
+		(*conf).MaxMessageSize = MaxMessageSize
+	}
+
+	if (*conf).AuthTimeout <= 0 {
+		(*conf).AuthTimeout = AuthTimeout
+	}
+
+	if (*conf).MinCompressSize <= 0 {
+		(*conf).MinCompressSize = MinCompressSize
+	}
 }
 
 func DefaultServerConfig() *MuxServerConfig {
 	return &MuxServerConfig{
-		MaxIncomingPacket: MaxIncomingPacket,
-		IsGzip:            false,
-		ReadTimeout:       ReadTimeout,
-		DialTimeout:       DialTimeout,
-		WriteTimeout:      WriteTimeout,
+		MaxIncomingPacket:     MaxIncomingPacket,
+		MaxConcurrentStreams:  MaxConcurrentStreams,
+		IsGzip:                false,
+		ReadTimeout:           ReadTimeout,
+		DialTimeout:           DialTimeout,
+		WriteTimeout:          WriteTimeout,
+		KeepaliveInterval:     KeepaliveInterval,
+		KeepaliveTimeout:      KeepaliveTimeout,
+		InitialWindowSize:     InitialWindowSize,
+		InitialConnWindowSize: InitialConnWindowSize,
+		MaxMessageSize:        MaxMessageSize,
+		MinCompressSize:       MinCompressSize,
+		AuthTimeout:           AuthTimeout,
 	}
 }
 
 func ProductionServerConfig() *MuxServerConfig {
 	return &MuxServerConfig{
-		MaxIncomingPacket: MaxIncomingPacket,
-		IsGzip:            false,
-		ReadTimeout:       ReadTimeout,
-		DialTimeout:       DialTimeout,
-		WriteTimeout:      WriteTimeout,
+		MaxIncomingPacket:     MaxIncomingPacket,
+		MaxConcurrentStreams:  MaxConcurrentStreams,
+		IsGzip:                false,
+		ReadTimeout:           ReadTimeout,
+		DialTimeout:           DialTimeout,
+		WriteTimeout:          WriteTimeout,
+		KeepaliveInterval:     KeepaliveInterval,
+		KeepaliveTimeout:      KeepaliveTimeout,
+		InitialWindowSize:     InitialWindowSize,
+		InitialConnWindowSize: InitialConnWindowSize,
+		MaxMessageSize:        MaxMessageSize,
+		MinCompressSize:       MinCompressSize,
+		AuthTimeout:           AuthTimeout,
 	}
 }
 
 func DevelopmentServerConfig() *MuxServerConfig {
 	return &MuxServerConfig{
-		MaxIncomingPacket: MaxIncomingPacket,
-		IsGzip:            false,
-		ReadTimeout:       ReadTimeout,
-		DialTimeout:       DialTimeout,
-		WriteTimeout:      WriteTimeout,
+		MaxIncomingPacket:     MaxIncomingPacket,
+		MaxConcurrentStreams:  MaxConcurrentStreams,
+		IsGzip:                false,
+		ReadTimeout:           ReadTimeout,
+		DialTimeout:           DialTimeout,
+		WriteTimeout:          WriteTimeout,
+		KeepaliveInterval:     KeepaliveInterval,
+		KeepaliveTimeout:      KeepaliveTimeout,
+		InitialWindowSize:     InitialWindowSize,
+		InitialConnWindowSize: InitialConnWindowSize,
+		MaxMessageSize:        MaxMessageSize,
+		MinCompressSize:       MinCompressSize,
+		AuthTimeout:           AuthTimeout,
 	}
 }
@@ -0,0 +1,277 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+   @Author: orbit-w
+   @File: settings
+   @2026 10月 周六 19:05
+*/
+
+// ProtocolVersion is the version of the frame layout spoken by this side,
+// it is exchanged in the opening SETTINGS frame of every physical connection.
+// Version 2 adds the compact frame layout, see Codec.
+// Version 3 acknowledges accepted virtual conns, see MuxClientConfig.WaitForAccept.
+// ProtocolVersion 当前协议版本，每个物理连接建立时通过SETTINGS帧交换，版本2支持紧凑帧格式，版本3支持虚拟连接接受确认
+const ProtocolVersion = 3
+
+const (
+	minProtocolVersion     = 1
+	compactProtocolVersion = 2
+	ackProtocolVersion     = 3
+)
+
+const (
+	settingsVersionLength = 1
+	settingLength         = 2 + 4
+)
+
+type SettingId uint16
+
+const (
+	SettingMaxConcurrentStreams SettingId = iota + 1
+	SettingMaxFrameSize
+	SettingInitialWindowSize
+	SettingFeatures
+	SettingMaxMessageSize
+	SettingInitialConnWindowSize
+)
+
+// Features advertised in SettingFeatures
+const (
+	FeatureServerPush  uint32 = 1 << iota //接收服务端推送
+	FeatureAcceptAck                      //对端接受虚拟连接后发送确认
+	FeatureCompression                    //解码带 FlagCompressed 的压缩帧
+)
+
+// Settings are the limits and capabilities one side announces to its peer,
+// a zero value means the setting was not announced.
+// Settings 一端向对端通告的限制与能力，0 表示未通告
+type Settings struct {
+	Version               uint8
+	MaxConcurrentStreams  uint32 //最大并发虚拟连接数
+	MaxFrameSize          uint32 //可接收的最大帧
+	InitialWindowSize     uint32 //虚拟连接的初始接收窗口
+	Features              uint32 //支持的特性
+	MaxMessageSize        uint32 //可重组的最大消息
+	InitialConnWindowSize uint32 //物理连接的初始接收窗口
+}
+
+func (s *Settings) HasFeature(f uint32) bool {
+	return s.Features&f != 0
+}
+
+// encode SETTINGS frame payload: version(1 byte) + n * (id(2 bytes) + value(4 bytes)),
+// unknown ids are skipped by the peer so that new settings can be added
+func (s *Settings) encode() []byte {
+	pairs := []struct {
+		id  SettingId
+		val uint32
+	}{
+		{SettingMaxConcurrentStreams, s.MaxConcurrentStreams},
+		{SettingMaxFrameSize, s.MaxFrameSize},
+		{SettingInitialWindowSize, s.InitialWindowSize},
+		{SettingFeatures, s.Features},
+		{SettingMaxMessageSize, s.MaxMessageSize},
+		{SettingInitialConnWindowSize, s.InitialConnWindowSize},
+	}
+
+	buf := make([]byte, settingsVersionLength, settingsVersionLength+len(pairs)*settingLength)
+	buf[0] = s.Version
+	for i := range pairs {
+		if pairs[i].val == 0 {
+			continue
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(pairs[i].id))
+		buf = binary.BigEndian.AppendUint32(buf, pairs[i].val)
+	}
+	return buf
+}
+
+func decodeSettings(data []byte) (Settings, error) {
+	s := Settings{}
+	if len(data) < settingsVersionLength || (len(data)-settingsVersionLength)%settingLength != 0 {
+		return s, errors.New("invalid settings frame")
+	}
+	s.Version = data[0]
+	for off := settingsVersionLength; off < len(data); off += settingLength {
+		val := binary.BigEndian.Uint32(data[off+2:])
+		switch SettingId(binary.BigEndian.Uint16(data[off:])) {
+		case SettingMaxConcurrentStreams:
+			s.MaxConcurrentStreams = val
+		case SettingMaxFrameSize:
+			s.MaxFrameSize = val
+		case SettingInitialWindowSize:
+			s.InitialWindowSize = val
+		case SettingFeatures:
+			s.Features = val
+		case SettingMaxMessageSize:
+			s.MaxMessageSize = val
+		case SettingInitialConnWindowSize:
+			s.InitialConnWindowSize = val
+		}
+	}
+	return s, nil
+}
+
+// sendSettings opens the handshake, it must be the first frame on the physical connection
+func (mux *Multiplexer) sendSettings() error {
+	return mux.sendMsg(&Msg{
+		Type: MessageSettings,
+		Data: mux.local.encode(),
+	})
+}
+
+// PeerSettings returns the settings announced by the peer, ok is false until the handshake completes
+// PeerSettings 返回对端通告的设置，握手完成前 ok 为 false
+func (mux *Multiplexer) PeerSettings() (s Settings, ok bool) {
+	mux.settingsMu.RLock()
+	defer mux.settingsMu.RUnlock()
+	if mux.peer == nil {
+		return
+	}
+	return *mux.peer, true
+}
+
+// peerWindowSize the initial send window of a new virtual conn
+func (mux *Multiplexer) peerWindowSize() int32 {
+	if mux.peer == nil || mux.peer.InitialWindowSize == 0 {
+		return InitialWindowSize
+	}
+	return int32(mux.peer.InitialWindowSize)
+}
+
+// handshakeTimeout bounds the wait for the peer's SETTINGS before the senders that used up the
+// default window treat it as a legacy peer, a peer sending any other frame first is one at once.
+// It runs from the first frame written to the transport, a slow connect does not count.
+const handshakeTimeout = time.Second
+
+// handshake tells whether the peer speaks the handshake, it is resolved by the first frame
+// of the peer: SETTINGS for the peers that do, any other frame for the legacy ones
+type handshake struct {
+	settings  atomic.Bool //the peer sent SETTINGS
+	once      sync.Once
+	startOnce sync.Once
+	resolved  chan struct{}
+}
+
+func (h *handshake) init() {
+	h.resolved = make(chan struct{})
+}
+
+// start is called once a frame was written to the transport, the peer's SETTINGS
+// is only awaited for handshakeTimeout from then on
+func (h *handshake) start() {
+	h.startOnce.Do(func() {
+		time.AfterFunc(handshakeTimeout, func() {
+			h.resolve(false)
+		})
+	})
+}
+
+func (h *handshake) resolve(negotiated bool) {
+	if negotiated {
+		h.settings.Store(true)
+	}
+	h.once.Do(func() {
+		close(h.resolved)
+	})
+}
+
+// pending returns a channel closed once the handshake is resolved, nil if it already is
+func (h *handshake) pending() <-chan struct{} {
+	select {
+	case <-h.resolved:
+		return nil
+	default:
+		return h.resolved
+	}
+}
+
+// negotiated reports whether the peer sent SETTINGS. The policies introduced along with the
+// handshake (flow control, fragments, PING, the stream id rules) only apply to such peers,
+// the legacy peers that never send SETTINGS predate them and are served as before.
+// negotiated 对端是否发送了SETTINGS；握手引入的流量控制、分片、PING与虚拟连接ID规则只对其生效，
+// 未发送SETTINGS的旧版本对端保持原有行为
+func (h *handshake) negotiated() bool {
+	return h.settings.Load()
+}
+
+// legacy reports whether the handshake is resolved and the peer turned out not to be negotiated
+func (h *handshake) legacy() bool {
+	return h.pending() == nil && !h.negotiated()
+}
+
+// awaitHandshake blocks until the peer's SETTINGS arrived or the peer turned out to be legacy,
+// bounded by ctx and the lifetime of the multiplexer
+func (mux *Multiplexer) awaitHandshake(ctx context.Context) error {
+	pending := mux.hs.pending()
+	if pending == nil {
+		return nil
+	}
+	select {
+	case <-pending:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-mux.ctx.Done():
+		return ErrConnDone
+	}
+}
+
+// handleSettings applies the peer's limits
+func handleSettings(mux *Multiplexer, in *Msg) {
+	peer, err := decodeSettings(in.Data)
+	if err != nil {
+		mux.closeWith(newDecodeErr(err))
+		return
+	}
+	if peer.Version < minProtocolVersion {
+		mux.connError(CodeProtocolError, "unsupported protocol version", ErrVersionMismatch)
+		return
+	}
+	if mux.hs.negotiated() {
+		// the windows were already adjusted to the first SETTINGS
+		mux.connError(CodeProtocolError, "duplicate SETTINGS", ErrProtocol)
+		return
+	}
+
+	mux.settingsMu.Lock()
+	if peer.InitialConnWindowSize > 0 {
+		// the connection window of the peer replaces the default one used so far
+		mux.sendQuota.replenish(int(peer.InitialConnWindowSize) - InitialConnWindowSize)
+	}
+	old := mux.peerWindowSize()
+	mux.peer = &peer
+	if delta := mux.peerWindowSize() - old; delta != 0 {
+		// the virtual conns opened before the handshake used the default window
+		mux.virtualConns.Range(func(vc *VirtualConn) {
+			vc.sendQuota.replenish(int(delta))
+		})
+	}
+	if peer.MaxConcurrentStreams > 0 && mux.isClient {
+		// the client follows the limit of virtual conns advertised by the server,
+		// a lower limit configured locally still applies
+		limit := int(peer.MaxConcurrentStreams)
+		if mux.conf.MaxVirtualConns > 0 {
+			limit = min(mux.conf.MaxVirtualConns, limit)
+		}
+		mux.virtualConns.SetMax(limit)
+	}
+	mux.maxFrameSize.Store(peer.MaxFrameSize)
+	mux.maxMessageSize.Store(peer.MaxMessageSize)
+	mux.hs.resolve(true)
+	mux.settingsMu.Unlock()
+
+	if peer.Version >= compactProtocolVersion {
+		// the frames queued before are still v1, the peer decodes both layouts
+		mux.codec.useCompact()
+	}
+}
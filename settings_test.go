@@ -0,0 +1,268 @@
+package mux
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: settings_test
+   @2026 10月 周六 19:40
+*/
+
+func TestSettings_Codec(t *testing.T) {
+	s := Settings{
+		Version:              ProtocolVersion,
+		MaxConcurrentStreams: 16,
+		InitialWindowSize:    64 * 1024,
+		Features:             FeatureServerPush,
+	}
+	data := s.encode()
+	// MaxFrameSize is not announced
+	assert.Equal(t, settingsVersionLength+3*settingLength, len(data))
+
+	out, err := decodeSettings(data)
+	assert.NoError(t, err)
+	assert.Equal(t, s, out)
+
+	// unknown settings are ignored
+	data = append(data, 0xff, 0xff, 0, 0, 0, 1)
+	out, err = decodeSettings(data)
+	assert.NoError(t, err)
+	assert.Equal(t, s, out)
+
+	_, err = decodeSettings(data[:len(data)-1])
+	assert.Error(t, err)
+}
+
+// 客户端遵循服务端通告的最大并发虚拟连接数
+func TestMultiplexer_Settings(t *testing.T) {
+	conf := DefaultServerConfig()
+	conf.MaxConcurrentStreams = 2
+	conf.InitialWindowSize = 128 * 1024
+	conf.InitialConnWindowSize = 4 * 1024 * 1024
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		return nil
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	waitSettings(t, multiplexer)
+
+	peer, _ := multiplexer.PeerSettings()
+	assert.Equal(t, uint8(ProtocolVersion), peer.Version)
+	assert.Equal(t, uint32(2), peer.MaxConcurrentStreams)
+	assert.Equal(t, uint32(128*1024), peer.InitialWindowSize)
+	assert.Equal(t, uint32(4*1024*1024), peer.InitialConnWindowSize)
+	assert.Equal(t, int64(4*1024*1024), multiplexer.(*Multiplexer).sendQuota.quota)
+	// both sides speak version 2, frames use the compact layout
+	assert.True(t, multiplexer.(*Multiplexer).codec.compact.Load())
+
+	for i := 0; i < 2; i++ {
+		vc, err := multiplexer.NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(128*1024), vc.(*VirtualConn).sendQuota.quota)
+	}
+	_, err := multiplexer.NewVirtualConn(context.Background())
+	assert.Equal(t, ErrVirtualConnUpLimit, err)
+}
+
+// 服务端通告的并发数更大时，客户端仍遵循本地的 MaxVirtualConns
+func TestMultiplexer_SettingsLocalLimit(t *testing.T) {
+	conf := DefaultServerConfig()
+	conf.MaxConcurrentStreams = 100
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		return nil
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, NewClientConfig(2))
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	for i := 0; i < 2; i++ {
+		_, err := multiplexer.NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+	}
+	_, err := multiplexer.NewVirtualConn(context.Background())
+	assert.Equal(t, ErrVirtualConnUpLimit, err)
+}
+
+// 客户端未配置 MaxVirtualConns 时，服务端通告的更大并发数同样生效
+func TestMultiplexer_SettingsPeerLimit(t *testing.T) {
+	conf := DefaultServerConfig()
+	conf.MaxConcurrentStreams = maxVirtualConns + 50
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		_, _ = conn.Recv(context.Background())
+		return nil
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn, DefaultClientConfig())
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	for i := 0; i < maxVirtualConns+50; i++ {
+		_, err := multiplexer.NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+	}
+	_, err := multiplexer.NewVirtualConn(context.Background())
+	assert.Equal(t, ErrVirtualConnUpLimit, err)
+}
+
+// waitSettings waits for the handshake, the limits of the peer apply from then on
+func waitSettings(t *testing.T, multiplexer IMux) {
+	assert.Eventually(t, func() bool {
+		_, ok := multiplexer.PeerSettings()
+		return ok
+	}, time.Second*5, time.Millisecond*5)
+}
+
+// legacyConn is a peer predating the handshake: it sends v1 frames and no SETTINGS,
+// and ignores every frame type it does not know
+type legacyConn struct {
+	t     *testing.T
+	conn  TransportConn
+	codec *Codec
+}
+
+func newLegacyConn(t *testing.T, conn TransportConn) *legacyConn {
+	return &legacyConn{t: t, conn: conn, codec: new(Codec)}
+}
+
+func (c *legacyConn) send(msg Msg) {
+	assert.NoError(c.t, c.conn.Send(c.codec.Encode(&msg).Data()))
+}
+
+// recv returns the next frame known to the legacy peers
+func (c *legacyConn) recv() (Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for {
+		in, err := c.conn.Recv(ctx)
+		if err != nil {
+			return Msg{}, err
+		}
+		msg, err := c.codec.DecodeV2(in)
+		if err != nil {
+			return Msg{}, err
+		}
+		switch msg.Type {
+		case MessageRaw, MessageStart, MessageFin:
+			return msg, nil
+		}
+	}
+}
+
+// 未发送SETTINGS的旧版本客户端，其ID不区分奇偶且可能乱序
+func TestMultiplexer_LegacyClient(t *testing.T) {
+	s := serveWithHandler(t, Dev, echoHandler)
+	defer s.Stop()
+
+	c := newLegacyConn(t, transport.DialContextWithOps(context.Background(), s.Addr()))
+	defer c.conn.Close()
+
+	for _, id := range []int64{2, 1, 3} {
+		c.send(Msg{Type: MessageStart, Id: id, Data: []byte("{}")})
+		c.send(Msg{Type: MessageRaw, Id: id, Data: []byte("hello")})
+		in, err := c.recv()
+		assert.NoError(t, err)
+		assert.Equal(t, Msg{Type: MessageRaw, Id: id, Data: []byte("hello")}, in)
+	}
+}
+
+// 未发送SETTINGS的旧版本客户端不支持服务端推送
+func TestMultiplexer_PushToLegacyClient(t *testing.T) {
+	refused := make(chan error, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		_, err := conn.Mux().NewVirtualConn(context.Background())
+		refused <- err
+		return nil
+	})
+	defer s.Stop()
+
+	c := newLegacyConn(t, transport.DialContextWithOps(context.Background(), s.Addr()))
+	defer c.conn.Close()
+	c.send(Msg{Type: MessageStart, Id: 1, Data: []byte("{}")})
+
+	select {
+	case err := <-refused:
+		assert.Equal(t, ErrPushDisabled, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("push to a legacy client not refused")
+	}
+}
+
+// 握手超时从首个帧写入传输层开始计算，建立连接较慢的对端不会被视为旧版本
+func TestMultiplexer_HandshakeSlowConnect(t *testing.T) {
+	a, b := net.Pipe()
+	peer := NewFramedConn(b, 0)
+	defer peer.Close()
+
+	multiplexer := NewMultiplexer(context.Background(), NewFramedConn(a, 0))
+	defer multiplexer.Close()
+	m := multiplexer.(*Multiplexer)
+
+	// the transport does not take the SETTINGS frame yet
+	time.Sleep(handshakeTimeout + time.Millisecond*200)
+	assert.NotNil(t, m.hs.pending())
+
+	_, err := peer.Recv(context.Background())
+	assert.NoError(t, err)
+	settings := Settings{Version: ProtocolVersion}
+	assert.NoError(t, peer.Send(new(Codec).Encode(&Msg{Type: MessageSettings, Data: settings.encode()}).Data()))
+	waitSettings(t, multiplexer)
+	assert.False(t, m.hs.legacy())
+}
+
+// 对端重复发送SETTINGS时以 CodeProtocolError 关闭物理连接，窗口不会被重复放大
+func TestMultiplexer_DuplicateSettings(t *testing.T) {
+	a, b := net.Pipe()
+	peer := NewFramedConn(b, 0)
+	defer peer.Close()
+
+	multiplexer := NewMultiplexer(context.Background(), NewFramedConn(a, 0))
+	defer multiplexer.Close()
+	closed := make(chan error, 1)
+	multiplexer.OnClose(func(err error) {
+		closed <- err
+	})
+	go func() {
+		// drain the frames of the multiplexer
+		for {
+			if _, err := peer.Recv(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	settings := Settings{Version: ProtocolVersion, InitialConnWindowSize: InitialConnWindowSize * 2}
+	assert.NoError(t, peer.Send(new(Codec).Encode(&Msg{Type: MessageSettings, Data: settings.encode()}).Data()))
+	waitSettings(t, multiplexer)
+	assert.Equal(t, int64(InitialConnWindowSize*2), multiplexer.(*Multiplexer).sendQuota.quota)
+
+	assert.NoError(t, peer.Send(new(Codec).Encode(&Msg{Type: MessageSettings, Data: settings.encode()}).Data()))
+	select {
+	case err := <-closed:
+		assert.ErrorIs(t, err, ErrProtocol)
+	case <-time.After(time.Second * 5):
+		t.Fatal("duplicate SETTINGS accepted")
+	}
+	assert.Equal(t, int64(InitialConnWindowSize*2), multiplexer.(*Multiplexer).sendQuota.quota)
+}
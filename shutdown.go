@@ -0,0 +1,92 @@
+package mux
+
+import (
+	"context"
+)
+
+/*
+   @Author: orbit-w
+   @File: shutdown
+   @2026 10月 周日 18:30
+*/
+
+// Shutdown gracefully stops the server: new physical connections are refused, the peers are
+// told to drain with GOAWAY so no new virtual conn is accepted, and Shutdown waits for the
+// running handlers to return and the physical connections to drain before stopping the transport.
+// When ctx expires first the server is stopped at once and ctx.Err() is returned.
+// Shutdown 优雅关闭服务：拒绝新的物理连接，向对端发送GOAWAY不再接受新的虚拟连接，
+// 等待处理函数返回、物理连接排空后关闭；ctx 到期时强制关闭并返回 ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		s.checkDrained()
+	}
+	drained := s.drained
+	s.mu.Unlock()
+
+	s.GoAway()
+
+	select {
+	case <-drained:
+		return s.Stop()
+	case <-ctx.Done():
+		_ = s.Stop()
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until the server is stopped by Stop or Shutdown, or its accept loop failed,
+// and returns the error the transport stopped with. Accept failures are only reported when
+// this package owns the accept loop (ServeListener, ServeTransport and TLS): meteor's
+// transport.IServer exposes nothing but Stop and Addr, a server started by ServeByConfig
+// over it only returns from Wait once it is stopped.
+// Wait 阻塞直到服务被 Stop 或 Shutdown 关闭或 Accept 失败，返回传输层关闭时的错误；
+// 仅 ServeListener、ServeTransport 与TLS服务报告 Accept 失败，基于 meteor 传输层的服务只在关闭后返回
+func (s *Server) Wait() error {
+	if s.ctx == nil {
+		return nil
+	}
+	<-s.ctx.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopErr
+}
+
+// fail stops the server after its transport failed, err is reported by Wait
+func (s *Server) fail(err error) {
+	s.mu.Lock()
+	if s.stopErr == nil {
+		s.stopErr = err
+	}
+	s.mu.Unlock()
+	_ = s.Stop()
+}
+
+// handlerStart is called before the handler of a virtual conn is started,
+// handlerDone once it returned
+func (s *Server) handlerStart() {
+	s.mu.Lock()
+	s.handlers++
+	s.mu.Unlock()
+}
+
+func (s *Server) handlerDone() {
+	s.mu.Lock()
+	s.handlers--
+	s.checkDrained()
+	s.mu.Unlock()
+}
+
+// checkDrained wakes up Shutdown once nothing is left to wait for, s.mu must be held
+func (s *Server) checkDrained() {
+	if !s.shuttingDown || s.drained == nil || s.handlers > 0 || len(s.muxes) > 0 {
+		return
+	}
+	select {
+	case <-s.drained:
+	default:
+		close(s.drained)
+	}
+}
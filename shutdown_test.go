@@ -0,0 +1,146 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: shutdown_test
+   @2026 10月 周日 18:50
+*/
+
+// Shutdown 等待处理中的虚拟连接结束后再关闭服务
+func TestServer_Shutdown(t *testing.T) {
+	release := make(chan struct{})
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return err
+		}
+		<-release
+		return conn.Send(in)
+	})
+	waited := make(chan error, 1)
+	go func() {
+		waited <- s.Wait()
+	}()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("hello")))
+	_, err = multiplexer.Ping(context.Background())
+	assert.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	// no new virtual conn once the server is shutting down
+	assert.Eventually(t, func() bool {
+		_, err := multiplexer.NewVirtualConn(context.Background())
+		return err == ErrGoAway
+	}, time.Second*5, time.Millisecond*10)
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the handler")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	// the handler in flight completes
+	close(release)
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+	_, err = vc.Recv(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+
+	select {
+	case err = <-shutdown:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("shutdown did not return")
+	}
+	assert.NoError(t, <-waited)
+
+	// new physical connections are refused
+	_, err = net.DialTimeout("tcp", s.Addr(), time.Second)
+	assert.Error(t, err)
+}
+
+// ctx 到期时强制关闭
+func TestServer_ShutdownTimeout(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		<-conn.Context().Done()
+		return nil
+	})
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	_, err = multiplexer.Ping(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = vc.Recv(context.Background())
+	assert.Error(t, err)
+	select {
+	case <-multiplexer.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("physical connection not closed")
+	}
+}
+
+func TestServer_ShutdownIdle(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.NoError(t, s.Wait())
+}
+
+// 基于 meteor 传输层的服务在 Stop 后由 Wait 返回，不视为失败
+func TestServer_WaitMeteorTransport(t *testing.T) {
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", echoHandler, DevelopmentServerConfig()))
+	// the accept loop is meteor's
+	_, owned := s.server.(*listenerServer)
+	assert.False(t, owned)
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- s.Wait()
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned before Stop")
+	case <-time.After(time.Millisecond * 100):
+	}
+	assert.NoError(t, s.Stop())
+	select {
+	case err := <-waited:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Wait not returned after Stop")
+	}
+}
@@ -0,0 +1,59 @@
+package mux
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+/*
+   @Author: orbit-w
+   @File: tls
+   @2026 10月 周日 22:50
+*/
+
+// DialTLS connects to addr over TCP and completes the TLS handshake, the connection is
+// framed by NewFramedConn and meant for a server started with MuxServerConfig.TLSConfig.
+// Set Certificates in conf to authenticate the client with mutual TLS.
+// DialTLS 建立TCP连接并完成TLS握手，用于连接配置了 MuxServerConfig.TLSConfig 的服务端；
+// 在 conf 中设置 Certificates 即可进行双向TLS认证
+func DialTLS(ctx context.Context, addr string, conf *tls.Config) (TransportConn, error) {
+	d := tls.Dialer{Config: conf}
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewFramedConn(c, MaxIncomingPacket), nil
+}
+
+// TLSConnectionState returns the state of the TLS connection the virtual conn runs on,
+// including the verified peer certificate chains, nil if the physical connection is not TLS
+// TLSConnectionState 返回虚拟连接所在TLS连接的状态（含已验证的对端证书链），非TLS连接返回nil
+func (vc *VirtualConn) TLSConnectionState() *tls.ConnectionState {
+	c, ok := vc.conn.(interface{ TLSConnectionState() *tls.ConnectionState })
+	if !ok {
+		return nil
+	}
+	return c.TLSConnectionState()
+}
+
+func (c *framedConn) TLSConnectionState() *tls.ConnectionState {
+	tc, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	st := tc.ConnectionState()
+	return &st
+}
+
+// serveTLS listens on addr and serves TLS connections through the framed transport
+func (s *Server) serveTLS(addr string, handleLoop func(conn IServerConn) error, conf *MuxServerConfig) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if err = s.ServeListener(tls.NewListener(ln, conf.TLSConfig), handleLoop, conf); err != nil {
+		_ = ln.Close()
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package mux
+
+import (
+	"context"
+	"testing"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: tls_test
+   @2026 10月 周日 23:40
+*/
+
+func TestVirtualConn_TLSConnectionStatePlain(t *testing.T) {
+	states := make(chan bool, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		states <- conn.TLSConnectionState() == nil
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, vc.(*VirtualConn).TLSConnectionState())
+	assert.True(t, <-states)
+}
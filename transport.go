@@ -0,0 +1,250 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/orbit-w/meteor/modules/net/transport"
+)
+
+/*
+   @Author: orbit-w
+   @File: transport
+   @2026 10月 周日 20:10
+*/
+
+// TransportConn is a framed physical connection, each Send is received by the peer as one
+// Recv. Send is called by a single goroutine, Recv by another one.
+// meteor's transport.IConn is a TransportConn, NewFramedConn adapts a net.Conn.
+// TransportConn 分帧的物理连接，meteor 的 transport.IConn 可直接使用，NewFramedConn 适配 net.Conn
+type TransportConn interface {
+	Send(data []byte) error
+	Recv(ctx context.Context) ([]byte, error)
+	Close() error
+}
+
+// TransportListener accepts framed physical connections, see Server.ServeTransport
+// TransportListener 接受分帧的物理连接
+type TransportListener interface {
+	Accept() (TransportConn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+var _ TransportConn = transport.IConn(nil)
+
+const framedHeaderLength = 4
+
+// NewFramedConn adapts a net.Conn to a TransportConn, each packet is prefixed with its
+// length as a 4 bytes big endian integer. Packets larger than maxIncomingPacket are
+// rejected, 0 means MaxIncomingPacket.
+// NewFramedConn 以4字节大端长度前缀分帧，将 net.Conn 适配为 TransportConn
+func NewFramedConn(c net.Conn, maxIncomingPacket uint32) TransportConn {
+	if maxIncomingPacket == 0 {
+		maxIncomingPacket = MaxIncomingPacket
+	}
+	return &framedConn{Conn: c, max: maxIncomingPacket}
+}
+
+type framedConn struct {
+	net.Conn     //exposes LocalAddr and RemoteAddr to the virtual conns
+	max          uint32
+	readTimeout  time.Duration //bounds each Recv, 0 means no limit
+	writeTimeout time.Duration //bounds each Send, 0 means no limit
+	wmu          sync.Mutex
+	rmu          sync.Mutex
+	hdr          [framedHeaderLength]byte
+}
+
+func (c *framedConn) Send(data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return err
+		}
+	}
+	var hdr [framedHeaderLength]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	bufs := net.Buffers{hdr[:], data}
+	_, err := bufs.WriteTo(c.Conn)
+	return err
+}
+
+// Recv reads the next packet, when ctx is canceled the pending read is interrupted
+// and the connection can no longer be used
+func (c *framedConn) Recv(ctx context.Context) ([]byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			_ = c.Conn.Close()
+		})
+		defer stop()
+	}
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return nil, c.recvErr(ctx, err)
+		}
+	}
+
+	if _, err := io.ReadFull(c.Conn, c.hdr[:]); err != nil {
+		return nil, c.recvErr(ctx, err)
+	}
+	n := binary.BigEndian.Uint32(c.hdr[:])
+	if n > c.max {
+		return nil, fmt.Errorf("%w: %d > %d", ErrPacketTooLarge, n, c.max)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return nil, c.recvErr(ctx, err)
+	}
+	return buf, nil
+}
+
+func (c *framedConn) recvErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return io.EOF
+	}
+	return err
+}
+
+// NewFramedListener adapts a net.Listener, the accepted connections are framed by NewFramedConn
+// NewFramedListener 将 net.Listener 适配为 TransportListener
+func NewFramedListener(ln net.Listener, maxIncomingPacket uint32) TransportListener {
+	return &framedListener{Listener: ln, max: maxIncomingPacket}
+}
+
+type framedListener struct {
+	net.Listener
+	max          uint32
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (ln *framedListener) Accept() (TransportConn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn := NewFramedConn(c, ln.max).(*framedConn)
+	conn.readTimeout, conn.writeTimeout = ln.readTimeout, ln.writeTimeout
+	return conn, nil
+}
+
+// DialFramed connects to addr on the named network, the connection is framed by NewFramedConn
+// DialFramed 建立 net.Conn 并以长度前缀分帧
+func DialFramed(ctx context.Context, network, addr string) (TransportConn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewFramedConn(c, MaxIncomingPacket), nil
+}
+
+// DialUnix connects to the Unix domain socket at path
+// DialUnix 连接 Unix 域套接字
+func DialUnix(ctx context.Context, path string) (TransportConn, error) {
+	return DialFramed(ctx, "unix", path)
+}
+
+// ListenUnix listens on the Unix domain socket at path, a stale socket file left by a
+// previous process is removed first. The socket file is removed when the listener is closed.
+// ListenUnix 监听 Unix 域套接字，先清理之前进程残留的套接字文件
+func ListenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("listen unix %s: address already in use", path)
+		}
+		_ = os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// ServeListener serves the physical connections accepted by ln, framed by NewFramedConn.
+// Each Recv and Send of a connection is bounded by conf.ReadTimeout and conf.WriteTimeout.
+// The framed transport does not compress, ErrGzipUnsupported is returned if conf.IsGzip is set.
+// Like ServeByConfig it returns once the server is started, an accept failure stops the
+// server and is reported by Wait.
+// ServeListener 在 net.Listener 上启动服务（如 Unix 域套接字），读写分别受 ReadTimeout、WriteTimeout 限制，
+// 不支持 IsGzip；Accept 失败时服务停止，错误由 Wait 返回
+func (s *Server) ServeListener(ln net.Listener, handleLoop func(conn IServerConn) error, conf *MuxServerConfig) error {
+	buildServerConfig(&conf)
+	if conf.IsGzip {
+		return ErrGzipUnsupported
+	}
+	return s.ServeTransport(&framedListener{
+		Listener:     ln,
+		max:          conf.MaxIncomingPacket,
+		readTimeout:  conf.ReadTimeout,
+		writeTimeout: conf.WriteTimeout,
+	}, handleLoop, conf)
+}
+
+// ServeTransport serves the physical connections accepted by ln, see ServeListener
+// ServeTransport 在自定义传输层上启动服务
+func (s *Server) ServeTransport(ln TransportListener, handleLoop func(conn IServerConn) error, conf *MuxServerConfig) error {
+	s.init(handleLoop, conf)
+	ts := &listenerServer{s: s, ln: ln}
+	s.server = ts
+	go ts.acceptLoop()
+	return nil
+}
+
+// listenerServer runs a TransportListener in place of meteor's server
+type listenerServer struct {
+	s      *Server
+	ln     TransportListener
+	closed atomic.Bool
+}
+
+func (ts *listenerServer) acceptLoop() {
+	for {
+		conn, err := ts.ln.Accept()
+		if err != nil {
+			if !ts.closed.Load() {
+				ts.s.fail(err)
+			}
+			return
+		}
+		go ts.s.serveConn(conn)
+	}
+}
+
+// Stop closes the listener and all physical connections
+func (ts *listenerServer) Stop() error {
+	if !ts.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	err := ts.ln.Close()
+
+	ts.s.mu.Lock()
+	// the connections accepted meanwhile are refused
+	ts.s.shuttingDown = true
+	muxes := make([]*Multiplexer, 0, len(ts.s.muxes))
+	for mux := range ts.s.muxes {
+		muxes = append(muxes, mux)
+	}
+	ts.s.mu.Unlock()
+	for i := range muxes {
+		_ = muxes[i].conn.Close()
+	}
+	return err
+}
+
+func (ts *listenerServer) Addr() string {
+	return ts.ln.Addr().String()
+}
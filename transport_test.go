@@ -0,0 +1,175 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+   @Author: orbit-w
+   @File: transport_test
+   @2026 10月 周日 20:40
+*/
+
+func echoHandler(conn IServerConn) error {
+	for {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return nil
+		}
+		if err = conn.Send(in); err != nil {
+			return err
+		}
+	}
+}
+
+func TestServer_ServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mux.sock")
+	ln, err := ListenUnix(path)
+	assert.NoError(t, err)
+
+	s := new(Server)
+	assert.NoError(t, s.ServeListener(ln, echoHandler, nil))
+	assert.Equal(t, path, s.Addr())
+
+	// the socket is in use
+	_, err = ListenUnix(path)
+	assert.Error(t, err)
+
+	conn, err := DialUnix(context.Background(), path)
+	assert.NoError(t, err)
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(vc.RemoteAddr().String(), path+"#"))
+	assert.NoError(t, vc.Send([]byte("hello")))
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(in))
+
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Wait())
+	select {
+	case <-multiplexer.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("physical connection not closed")
+	}
+}
+
+func TestListenUnix_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	old, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	old.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.NoError(t, old.Close())
+
+	ln, err := ListenUnix(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ln.Close())
+}
+
+func TestServer_ServeListenerTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := new(Server)
+	assert.NoError(t, s.ServeListener(ln, echoHandler, DefaultServerConfig()))
+	defer s.Stop()
+
+	conn, err := DialFramed(context.Background(), "tcp", s.Addr())
+	assert.NoError(t, err)
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	// large messages go through the fragmentation of the multiplexer
+	data := make([]byte, MaxFragmentSize*3)
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send(data))
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), len(in))
+}
+
+type brokenListener struct {
+	net.Listener
+	err error
+}
+
+func (ln *brokenListener) Accept() (TransportConn, error) {
+	return nil, ln.err
+}
+
+// Accept 失败时服务停止，错误由 Wait 返回
+func TestServer_ServeTransportAcceptFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	broken := errors.New("accept failed")
+
+	s := new(Server)
+	assert.NoError(t, s.ServeTransport(&brokenListener{Listener: ln, err: broken}, echoHandler, nil))
+	assert.ErrorIs(t, s.Wait(), broken)
+}
+
+func TestFramedConn_PacketTooLarge(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	in, out := NewFramedConn(a, 8), NewFramedConn(b, 8)
+
+	go func() {
+		_ = out.Send([]byte("small"))
+		_ = out.Send(make([]byte, 16))
+	}()
+	data, err := in.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+	_, err = in.Recv(context.Background())
+	assert.ErrorIs(t, err, ErrPacketTooLarge)
+}
+
+// 分帧传输的读写受 ReadTimeout、WriteTimeout 限制
+func TestServer_ServeListenerTimeouts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conf := DefaultServerConfig()
+	conf.ReadTimeout = time.Millisecond * 100
+	s := new(Server)
+	assert.NoError(t, s.ServeListener(ln, echoHandler, conf))
+	defer s.Stop()
+
+	// an idle client is disconnected
+	c, err := net.Dial("tcp", s.Addr())
+	assert.NoError(t, err)
+	defer c.Close()
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(time.Second*5)))
+	_, err = io.Copy(io.Discard, c)
+	assert.NoError(t, err)
+
+	// the peer stops reading
+	a, b := net.Pipe()
+	defer b.Close()
+	conn := &framedConn{Conn: a, max: MaxIncomingPacket, writeTimeout: time.Millisecond * 50}
+	err = conn.Send([]byte("hello"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+// 分帧传输不支持 IsGzip
+func TestServer_ServeListenerGzip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	conf := DefaultServerConfig()
+	conf.IsGzip = true
+	assert.ErrorIs(t, new(Server).ServeListener(ln, echoHandler, conf), ErrGzipUnsupported)
+}
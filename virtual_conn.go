@@ -2,12 +2,16 @@ package mux
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
+	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/orbit-w/meteor/modules/net/network"
-	"github.com/orbit-w/meteor/modules/net/packet"
-	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/orbit-w/mux-go/metadata"
 )
 
 /*
@@ -17,14 +21,21 @@ import (
 */
 
 type IConn interface {
-	// Send for a virtual conn, it is safe to call Send in multiple goroutines
-	// 中文：对于同一个虚拟连接，可以在多个goroutine中安全地调用Send
+	// Send for a virtual conn, it is safe to call Send in multiple goroutines.
+	// Send blocks while the send window of the virtual conn or of the physical
+	// connection is exhausted, until the peer consumes data or the virtual conn is done.
+	// Send returns once the message is queued, it is written by the scheduler of the multiplexer.
+	// 中文：对于同一个虚拟连接，可以在多个goroutine中安全地调用Send.
+	// 当虚拟连接或物理连接的发送窗口耗尽时，Send会阻塞，直到对端消费数据或虚拟连接结束。
+	// 消息入队后Send即返回，由多路复用器的调度器写入物理连接。
 	Send(data []byte) error
 
 	// Recv blocks until it receives a message into m or the virtual conn is
-	// done. It returns io.EOF when the virtual conn completes successfully.
+	// done. It returns io.EOF when the virtual conn completes successfully,
+	// and a *StatusError when the peer's handler failed.
 	// Under normal circumstances, it is necessary to call the Recv method in a goroutine to receive messages.
-	// 中文：Recv阻塞，直到将消息接收到m中或虚拟连接完成。当虚拟连接成功完成时，它将返回io.EOF.
+	// 中文：Recv阻塞，直到将消息接收到m中或虚拟连接完成。当虚拟连接成功完成时，它将返回io.EOF，
+	// 对端处理函数返回错误时返回 *StatusError.
 	// 在正常情况下，需要在一个goroutine中调用Recv方法接收消息。
 	Recv(ctx context.Context) ([]byte, error)
 
@@ -32,37 +43,144 @@ type IConn interface {
 	//After calling, subsequent sending will be terminated.
 	//中文：CloseSend关闭虚拟连接的发送方向。调用后，将终止后续发送。
 	CloseSend() error
+
+	// Reset aborts the virtual conn in both directions, the peer's Recv returns a *ResetError
+	// carrying code and reason.
+	// 中文：Reset 立即终止虚拟连接，对端的Recv会返回携带 code 和 reason 的 *ResetError
+	Reset(code Code, reason string) error
+
+	// Header blocks until the peer sends its header metadata, the first message
+	// or finishes the virtual conn, the header is nil if the peer sent none.
+	// 中文：Header 阻塞直到收到对端的header元数据、第一条消息或虚拟连接结束
+	Header() (metadata.MD, error)
+
+	// Trailer returns the trailer metadata sent by the peer when it finished the virtual conn,
+	// it is only available once Recv returned an error.
+	// 中文：Trailer 返回对端结束虚拟连接时发送的trailer元数据，Recv返回错误后才可用
+	Trailer() metadata.MD
+
+	// SetDeadline sets the read and write deadlines, Recv and Send calls pending or made after
+	// the deadline fail with os.ErrDeadlineExceeded (a net.Error whose Timeout is true).
+	// A zero value disables the deadline, deadlines can be reset like those of net.Conn.
+	// 中文：SetDeadline 设置读写截止时间，到期后阻塞中及之后的Recv/Send返回 os.ErrDeadlineExceeded，零值表示不超时
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+
+	// LocalAddr and RemoteAddr return the address of the physical connection plus the stream id, see Addr
+	// 中文：返回物理连接地址加虚拟连接ID
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+
+	// SetWeight changes the share of the physical connection the virtual conn gets
+	// while other virtual conns have data pending, see WithWeight.
+	// 中文：SetWeight 修改虚拟连接的调度权重
+	SetWeight(weight uint8)
 }
 
 type IServerConn interface {
 	Send(data []byte) error
 	Recv(ctx context.Context) ([]byte, error)
 	Context() context.Context
+
+	// CloseSend closes the send direction, the client's Recv returns io.EOF
+	// while the client can still send and the handler keeps receiving.
+	// 中文：CloseSend 关闭服务端的发送方向，客户端的Recv返回io.EOF，客户端仍可继续发送
+	CloseSend() error
+
+	// SetHeader merges md into the header metadata sent ahead of the first message,
+	// it fails with ErrHeaderSent once the header is out.
+	// 中文：SetHeader 设置在第一条消息之前发送的header元数据
+	SetHeader(md metadata.MD) error
+
+	// SendHeader sends the header metadata at once, it can be called at most once.
+	// 中文：SendHeader 立即发送header元数据，最多调用一次
+	SendHeader(md metadata.MD) error
+
+	// SetTrailer merges md into the trailer metadata sent when the handler returns.
+	// 中文：SetTrailer 设置处理函数返回时随状态一起发送的trailer元数据
+	SetTrailer(md metadata.MD)
+
+	// Close finishes the virtual conn, the client's Recv returns io.EOF once
+	// the data sent before has been read.
+	// 中文：Close 结束虚拟连接，客户端读完之前发送的数据后Recv返回io.EOF
 	Close()
+
+	// Reset aborts the virtual conn, the client's Recv returns a *ResetError
+	// 中文：Reset 立即终止虚拟连接，客户端的Recv会返回 *ResetError
+	Reset(code Code, reason string) error
+
+	// SetDeadline sets the read and write deadlines, see IConn.SetDeadline
+	// 中文：SetDeadline 设置读写截止时间
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+
+	// SetWeight changes the scheduling weight of the data sent by the server, see WithWeight
+	// 中文：SetWeight 修改服务端发送数据的调度权重
+	SetWeight(weight uint8)
+
+	// TLSConnectionState returns the state of the TLS connection, including the verified
+	// certificate chains of the client, nil if the physical connection is not TLS.
+	// 中文：TLSConnectionState 返回TLS连接状态（含已验证的客户端证书链），非TLS连接返回nil
+	TLSConnectionState() *tls.ConnectionState
+
+	// Mux returns the multiplexer of the physical connection the virtual conn belongs to,
+	// it can be used to open new virtual conns to the peer (server push).
+	// 中文：Mux 返回虚拟连接所属物理连接的多路复用器，可用于向对端发起新的虚拟连接（服务端推送）
+	Mux() IMux
 }
 
 type VirtualConn struct {
 	id     int64
+	client bool //true if this side opened the virtual conn
 	state  atomic.Uint32
-	conn   transport.IConn
+	conn   TransportConn
 	codec  *Codec
 	mux    *Multiplexer
 	rb     *network.BlockReceiver
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	sendQuota *writeQuota //stream-level send window
+	inFlow    *inFlow     //stream-level receive window
+
+	weight atomic.Uint32 //scheduling weight of the frames sent
+	sendMu sync.Mutex    //keeps the fragments of a message together in the send queue
+	frags  []byte        //the fragments of the message being reassembled, only touched by recvLoop
+
+	compressor Compressor //chosen by the opener of the virtual conn, nil if its messages are not compressed
+
+	rd *deadline //read deadline
+	wd *deadline //write deadline, derived from ctx
+
+	hdr      header    //response header and trailer metadata
+	accepted acceptAck //the peer acknowledged the virtual conn
 }
 
-func virtualConn(f context.Context, _id int64, _conn transport.IConn, mux *Multiplexer) *VirtualConn {
+func virtualConn(f context.Context, _id int64, _conn TransportConn, mux *Multiplexer, client bool) *VirtualConn {
 	ctx, cancel := context.WithCancel(f)
 	s := &VirtualConn{
-		id:     _id,
-		conn:   _conn,
-		rb:     network.NewBlockReceiver(),
-		codec:  new(Codec),
-		ctx:    ctx,
-		cancel: cancel,
-		mux:    mux,
+		id:       _id,
+		client:   client,
+		conn:     _conn,
+		rb:       network.NewBlockReceiver(),
+		codec:    mux.codec,
+		ctx:      ctx,
+		cancel:   cancel,
+		mux:      mux,
+		hdr:      header{done: make(chan struct{})},
+		accepted: acceptAck{ch: make(chan struct{})},
 	}
+	s.rd = newDeadline(context.Background())
+	s.wd = newDeadline(ctx)
+	s.sendQuota = newWriteQuota(mux.peerWindowSize(), ctx.Done(), &mux.hs)
+	s.inFlow = newInFlow(mux.local.InitialWindowSize,
+		flowLimit(mux.local.InitialWindowSize, InitialWindowSize, mux.local.MaxMessageSize))
+	s.weight.Store(uint32(DefaultWeight))
 	return s
 }
 
@@ -75,71 +193,281 @@ func (vc *VirtualConn) Send(data []byte) error {
 }
 
 func (vc *VirtualConn) Recv(ctx context.Context) ([]byte, error) {
-	return vc.rb.Recv(ctx)
+	rd := vc.rd.context()
+	if vc.rd.exceeded(rd) {
+		return nil, os.ErrDeadlineExceeded
+	}
+	ctx, stop := vc.recvContext(ctx, rd)
+	in, err := vc.rb.Recv(ctx)
+	stop()
+	if err != nil {
+		return nil, deadlineErr(vc.rd, rd, vc.recvErr(err))
+	}
+	if vc.compressor != nil {
+		return vc.decompress(in)
+	}
+	vc.onRead(len(in))
+	return in, nil
 }
 
 func (vc *VirtualConn) CloseSend() error {
-	if !vc.isClient() {
-		return nil
-	}
 	return vc.send(nil, true)
 }
 
 func (vc *VirtualConn) Close() {
-	vc.rb.OnClose(io.EOF)
+	vc.finish(nil)
+}
+
+func (vc *VirtualConn) Reset(code Code, reason string) error {
+	if _, exist := vc.mux.virtualConns.GetAndDel(vc.Id()); !exist {
+		return ErrConnDone
+	}
+	// the data still queued is of no use to the peer
+	vc.mux.sched.drop(vc.Id())
+	err := vc.mux.sendMsg(&Msg{
+		Type: MessageRst,
+		Id:   vc.Id(),
+		Data: encodeReset(code, reason),
+	})
+	vc.OnClose(&ResetError{Code: code, Reason: reason})
+	vc.mux.tryDrain()
+	return err
+}
+
+func (vc *VirtualConn) SetWeight(weight uint8) {
+	vc.weight.Store(uint32(weight))
 }
 
+// OnClose closes the virtual conn in both directions
 func (vc *VirtualConn) OnClose(err error) {
+	vc.state.Store(ConnClosed)
 	vc.rb.OnClose(err)
+	vc.headerDone()
+	vc.cancel()
+	vc.rd.stop()
+	vc.wd.stop()
+	// the connection-level credit of unread data was returned when it arrived
+	vc.inFlow.close()
+}
+
+// closeRecv only closes the receiving direction, the virtual conn can still send
+func (vc *VirtualConn) closeRecv(err error) {
+	vc.transit(ConnReadDone)
+	vc.rb.OnClose(err)
+	vc.headerDone()
+}
+
+// transit closes one direction of the virtual conn, done is ConnWriteDone or ConnReadDone,
+// it returns false if that direction was already closed
+func (vc *VirtualConn) transit(done uint32) bool {
+	for {
+		cur := vc.state.Load()
+		next := done
+		switch cur {
+		case ConnActive:
+		case ConnWriteDone, ConnReadDone:
+			if cur == done {
+				return false
+			}
+			next = ConnClosed
+		default:
+			return false
+		}
+		if vc.state.CompareAndSwap(cur, next) {
+			return true
+		}
+	}
+}
+
+// canSend reports whether the send direction is still open
+func (vc *VirtualConn) canSend() bool {
+	state := vc.state.Load()
+	return state == ConnActive || state == ConnReadDone
 }
 
 func (vc *VirtualConn) Context() context.Context {
 	return vc.ctx
 }
 
-func (vc *VirtualConn) put(in []byte) {
-	vc.rb.Put(in, nil)
+func (vc *VirtualConn) Mux() IMux {
+	return vc.mux
+}
+
+// put receives a data frame, more reports that the message continues in the next frame,
+// compressed that its payload is compressed
+func (vc *VirtualConn) put(in []byte, more, compressed bool) {
+	ok, err := vc.inFlow.onData(uint32(len(in)), vc.mux.hs.negotiated())
+	if err != nil {
+		vc.frags = nil
+		_ = vc.Reset(CodeFlowControlError, "stream window exceeded")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	vc.headerDone()
+	if vc.compressor == nil {
+		if compressed {
+			_ = vc.Reset(CodeProtocolError, "compressed frame on a virtual conn without compressor")
+			return
+		}
+		if !more && vc.frags == nil {
+			vc.rb.Put(in, nil)
+			return
+		}
+	} else if vc.frags == nil {
+		// every message is prefixed by a flag byte telling Recv whether to decompress it
+		var flag byte
+		if compressed {
+			flag = 1
+		}
+		vc.frags = append(make([]byte, 0, len(in)+1), flag)
+	}
+
+	if max := vc.mux.local.MaxMessageSize; max > 0 && len(vc.frags)+len(in) > int(max)+vc.flagSize() {
+		vc.frags = nil
+		_ = vc.Reset(CodeMessageTooLarge, "message exceeds the max message size")
+		return
+	}
+	vc.frags = append(vc.frags, in...)
+	if !more {
+		msg := vc.frags
+		vc.frags = nil
+		vc.rb.Put(msg, nil)
+	}
+}
+
+// flagSize the length of the flag prefixed to the messages of a virtual conn with a compressor
+func (vc *VirtualConn) flagSize() int {
+	if vc.compressor != nil {
+		return 1
+	}
+	return 0
+}
+
+// onRead returns the consumed bytes to the stream-level window
+func (vc *VirtualConn) onRead(n int) {
+	if n == 0 {
+		return
+	}
+	if wu, ok := vc.inFlow.onRead(uint32(n)); ok && wu > 0 {
+		vc.mux.sendWindowUpdate(vc.Id(), wu)
+	}
 }
 
 func (vc *VirtualConn) send(data []byte, isLast bool) error {
 	if isLast {
-		if !vc.state.CompareAndSwap(ConnActive, ConnWriteDone) {
+		if !vc.transit(ConnWriteDone) {
 			return ErrConnDone
 		}
 
 	} else {
-		if vc.state.Load() != ConnActive {
+		if !vc.canSend() {
 			return ErrConnDone
 		}
 	}
 
-	msg := Msg{
-		Type: MessageRaw,
-		Id:   vc.Id(),
-		Data: data,
-		End:  isLast,
+	if max := vc.mux.maxMessageSize.Load(); max > 0 && uint32(len(data)) > max {
+		return ErrMessageTooLarge
 	}
-	return vc.sendMsg(&msg)
-}
 
-func (vc *VirtualConn) sendMsg(msg *Msg) error {
-	fp := vc.codec.Encode(msg)
-	if err := vc.conn.Send(fp.Data()); err != nil {
+	// compressed before taking quota, the windows count the bytes on the wire
+	data, compressed, err := vc.compress(data)
+	if err != nil {
 		return err
 	}
-	packet.Return(fp)
-	return nil
+
+	vc.sendMu.Lock()
+	defer vc.sendMu.Unlock()
+
+	wd := vc.wd.context()
+	// the End frame carries no data and is not held back by the write deadline,
+	// the send direction is already closed and the peer must learn it
+	if !isLast && vc.wd.exceeded(wd) {
+		return os.ErrDeadlineExceeded
+	}
+	if sz := int32(len(data)); sz > 0 {
+		if err := vc.sendQuota.get(wd, sz); err != nil {
+			return deadlineErr(vc.wd, wd, err)
+		}
+		if err := vc.mux.sendQuota.get(wd, sz); err != nil {
+			// nothing was sent, give the stream quota back
+			vc.sendQuota.replenish(int(sz))
+			return deadlineErr(vc.wd, wd, err)
+		}
+	}
+
+	if !vc.isClient() {
+		// the pending header goes ahead of the first message
+		if err := vc.flushHeader(false); err != nil {
+			return err
+		}
+	}
+
+	if isLast {
+		fp := vc.codec.Encode(&Msg{
+			Type: MessageRaw,
+			Id:   vc.Id(),
+			End:  true,
+		})
+		return vc.mux.sched.pushInOrder(vc.Id(), fp)
+	}
+
+	// large messages are split into fragments, the scheduler interleaves them
+	// with the frames of the other virtual conns
+	size := vc.mux.fragmentSize()
+	for {
+		msg := Msg{
+			Type:       MessageRaw,
+			Id:         vc.Id(),
+			Data:       data,
+			Compressed: compressed,
+		}
+		if len(data) > size {
+			msg.Type = MessageFragment
+			msg.Data = data[:size]
+		}
+		data = data[len(msg.Data):]
+		if err := vc.mux.sched.push(vc.Id(), uint8(vc.weight.Load()), vc.codec.Encode(&msg)); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// finish removes the virtual conn and notifies the peer with a Fin frame carrying st,
+// unless it was already reset or the physical connection is broken.
+// Close the stream
+// Simultaneously disconnect the input and output of virtual connections
+// 确保同时掐断虚拟连接的输入和输出
+func (vc *VirtualConn) finish(st *StatusError) {
+	if _, exist := vc.mux.virtualConns.GetAndDel(vc.Id()); exist {
+		err := vc.rb.GetErr()
+		if err == nil || err == io.EOF {
+			if !vc.isClient() {
+				_ = vc.flushHeader(false)
+			}
+			vc.sendToClientNtfFin(st)
+		}
+	}
+	vc.OnClose(io.EOF)
+	vc.mux.tryDrain()
 }
 
 // 远程发送关闭信号
-func (vc *VirtualConn) sendToClientNtfFin() {
-	msg := Msg{
+func (vc *VirtualConn) sendToClientNtfFin(st *StatusError) {
+	fp := vc.codec.Encode(&Msg{
 		Type: MessageFin,
 		Id:   vc.Id(),
-	}
-	_ = vc.sendMsg(&msg)
+		Data: encodeStatus(st, vc.takeTrailer()),
+	})
+	_ = vc.mux.sched.pushInOrder(vc.Id(), fp)
 }
 
+// isClient reports whether this side opened the virtual conn
 func (vc *VirtualConn) isClient() bool {
-	return vc.mux.isClient
+	return vc.client
 }
@@ -2,9 +2,14 @@ package mux
 
 import (
 	"context"
+	"errors"
 	"github.com/orbit-w/meteor/modules/net/transport"
+	"github.com/orbit-w/mux-go/metadata"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
 	"testing"
+	"time"
 )
 
 /*
@@ -37,6 +42,303 @@ func TestVirtualConn_CloseSend(t *testing.T) {
 	ivc, err := multiplexer.NewVirtualConn(context.Background())
 	assert.NoError(t, err)
 	vc := ivc.(*VirtualConn)
-	vc.mux.isClient = false
+	vc.client = false
 	assert.NoError(t, vc.CloseSend())
 }
+
+func TestVirtualConn_Reset(t *testing.T) {
+	serverErr := make(chan error, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				serverErr <- err
+				return nil
+			}
+			if string(in) == "reset" {
+				assert.NoError(t, conn.Reset(CodeInternalError, "server reset"))
+				assert.ErrorIs(t, conn.Send([]byte("Hello")), ErrConnDone)
+				return nil
+			}
+		}
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	// client side reset
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("Hello")))
+	assert.NoError(t, vc.Reset(CodeCancel, "client reset"))
+	assert.ErrorIs(t, vc.Reset(CodeCancel, "client reset"), ErrConnDone)
+	_, err = vc.Recv(context.Background())
+	var re *ResetError
+	assert.ErrorAs(t, err, &re)
+	assert.False(t, re.Remote)
+
+	err = <-serverErr
+	assert.ErrorAs(t, err, &re)
+	assert.True(t, re.Remote)
+	assert.Equal(t, CodeCancel, re.Code)
+	assert.Equal(t, "client reset", re.Reason)
+
+	// server side reset
+	vc, err = multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("reset")))
+	_, err = vc.Recv(context.Background())
+	assert.ErrorAs(t, err, &re)
+	assert.True(t, re.Remote)
+	assert.Equal(t, CodeInternalError, re.Code)
+	assert.Equal(t, "server reset", re.Reason)
+	assert.ErrorIs(t, vc.Send([]byte("Hello")), ErrConnDone)
+}
+
+func TestVirtualConn_ServerClose(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		assert.NoError(t, conn.Send([]byte("bye")))
+		conn.Close()
+		time.Sleep(time.Second)
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	start := time.Now()
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "bye", string(in))
+	_, err = vc.Recv(context.Background())
+	assert.Equal(t, io.EOF, err)
+	// the client learns about Close without waiting for the handler to return
+	assert.Less(t, time.Since(start), time.Millisecond*500)
+}
+
+// 超过 MaxIncomingPacket 的消息拆分为分片发送，接收端重组
+func TestVirtualConn_Fragment(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		for {
+			in, err := conn.Recv(context.Background())
+			if err != nil {
+				return nil
+			}
+			if err = conn.Send(in); err != nil {
+				return err
+			}
+		}
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	waitSettings(t, multiplexer)
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+
+	msg := make([]byte, MaxIncomingPacket*4+1)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for _, size := range []int{MaxFragmentSize, MaxFragmentSize + 1, len(msg)} {
+		assert.NoError(t, vc.Send(msg[:size]))
+		in, err := vc.Recv(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, msg[:size], in)
+	}
+}
+
+// 未发送SETTINGS的旧版本对端不支持分片，消息整体发送
+func TestVirtualConn_FragmentLegacyPeer(t *testing.T) {
+	a, b := net.Pipe()
+	peer := newLegacyConn(t, NewFramedConn(b, 0))
+	defer peer.conn.Close()
+
+	multiplexer := NewMultiplexer(context.Background(), NewFramedConn(a, 0))
+	defer multiplexer.Close()
+
+	go func() {
+		vc, err := multiplexer.NewVirtualConn(context.Background())
+		if assert.NoError(t, err) {
+			assert.NoError(t, vc.Send(make([]byte, MaxFragmentSize*4)))
+		}
+	}()
+
+	start, err := peer.recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int8(MessageStart), start.Type)
+	in, err := peer.recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int8(MessageRaw), in.Type)
+	assert.Equal(t, MaxFragmentSize*4, len(in.Data))
+}
+
+func TestVirtualConn_MessageTooLarge(t *testing.T) {
+	conf := DefaultServerConfig()
+	conf.MaxMessageSize = MaxFragmentSize * 2
+	s := new(Server)
+	assert.NoError(t, s.ServeByConfig("localhost:0", func(conn IServerConn) error {
+		_, err := conn.Recv(context.Background())
+		return err
+	}, conf))
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+	assert.Eventually(t, func() bool {
+		_, ok := multiplexer.PeerSettings()
+		return ok
+	}, time.Second*5, time.Millisecond*10)
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ErrMessageTooLarge, vc.Send(make([]byte, MaxFragmentSize*2+1)))
+
+	// the receiver enforces its limit even if the sender ignores it
+	multiplexer.(*Multiplexer).maxMessageSize.Store(0)
+	assert.NoError(t, vc.Send(make([]byte, MaxFragmentSize*2+1)))
+	_, err = vc.Recv(context.Background())
+	var re *ResetError
+	assert.ErrorAs(t, err, &re)
+	assert.True(t, re.Remote)
+	assert.Equal(t, CodeMessageTooLarge, re.Code)
+}
+
+// 服务端关闭发送方向后继续接收客户端的上传
+func TestVirtualConn_ServerCloseSend(t *testing.T) {
+	uploaded := make(chan int, 1)
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		assert.NoError(t, conn.Send([]byte("ready")))
+		assert.NoError(t, conn.CloseSend())
+		assert.ErrorIs(t, conn.Send([]byte("Hello")), ErrConnDone)
+		assert.ErrorIs(t, conn.CloseSend(), ErrConnDone)
+
+		var n int
+		for {
+			if _, err := conn.Recv(context.Background()); err != nil {
+				assert.Equal(t, io.EOF, err)
+				uploaded <- n
+				return nil
+			}
+			n++
+		}
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	in, err := vc.Recv(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ready", string(in))
+	_, err = vc.Recv(context.Background())
+	assert.Equal(t, io.EOF, err)
+
+	// the client keeps sending after the server closed its direction
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, vc.Send([]byte("chunk")))
+	}
+	assert.NoError(t, vc.CloseSend())
+	assert.Equal(t, ConnClosed, vc.(*VirtualConn).state.Load())
+
+	select {
+	case n := <-uploaded:
+		assert.Equal(t, 3, n)
+	case <-time.After(time.Second * 5):
+		t.Fatal("upload not received")
+	}
+}
+
+// 处理函数返回的错误作为虚拟连接的状态传递给客户端
+func TestVirtualConn_Status(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		in, err := conn.Recv(context.Background())
+		if err != nil {
+			return err
+		}
+		switch string(in) {
+		case "status":
+			return NewStatusError(CodeInternalError, "database unavailable")
+		case "error":
+			return errors.New("boom")
+		case "eof":
+			return io.EOF
+		}
+		return nil
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	recvErr := func(req string) error {
+		vc, err := multiplexer.NewVirtualConn(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, vc.Send([]byte(req)))
+		_, err = vc.Recv(context.Background())
+		return err
+	}
+
+	var se *StatusError
+	assert.ErrorAs(t, recvErr("status"), &se)
+	assert.Equal(t, CodeInternalError, se.Code)
+	assert.Equal(t, "database unavailable", se.Message)
+
+	assert.ErrorAs(t, recvErr("error"), &se)
+	assert.Equal(t, CodeUnknown, se.Code)
+	assert.Equal(t, "boom", se.Message)
+
+	assert.Equal(t, io.EOF, recvErr("eof"))
+	assert.Equal(t, io.EOF, recvErr("ok"))
+}
+
+// 服务端 CloseSend 之后返回的状态与trailer仍传递给客户端
+func TestVirtualConn_StatusAfterCloseSend(t *testing.T) {
+	s := serveWithHandler(t, Dev, func(conn IServerConn) error {
+		if _, err := conn.Recv(context.Background()); err != nil {
+			return err
+		}
+		assert.NoError(t, conn.CloseSend())
+		conn.SetTrailer(metadata.MD{"cost": "12"})
+		return NewStatusError(CodeInternalError, "database unavailable")
+	})
+	defer s.Stop()
+
+	conn := transport.DialContextWithOps(context.Background(), s.Addr())
+	multiplexer := NewMultiplexer(context.Background(), conn)
+	defer multiplexer.Close()
+
+	vc, err := multiplexer.NewVirtualConn(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, vc.Send([]byte("req")))
+	select {
+	case <-vc.(*VirtualConn).Context().Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("virtual conn not finished")
+	}
+
+	_, err = vc.Recv(context.Background())
+	var se *StatusError
+	if assert.ErrorAs(t, err, &se) {
+		assert.Equal(t, CodeInternalError, se.Code)
+		assert.Equal(t, "database unavailable", se.Message)
+	}
+	trailer := vc.Trailer()
+	cost, _ := trailer.GetString("cost")
+	assert.Equal(t, "12", cost)
+}